@@ -0,0 +1,175 @@
+package msgp
+
+import (
+	"math"
+)
+
+// The Append functions append the encoded bytes of a value to a byte slice and return
+// the extended slice, like the append built-in. They choose the same formats as the Pack
+// functions, and allocate only when the capacity of the slice is not enough.
+// The lengths of str, bin, ext, array and map must not exceed math.MaxUint32.
+
+// AppendNil appends a nil value to 'b'.
+func AppendNil(b []byte) []byte {
+	return append(b, 0xc0)
+}
+
+// AppendBool appends a bool value to 'b'.
+func AppendBool(b []byte, value bool) []byte {
+	if value {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+// AppendInt appends an integer value to 'b'.
+func AppendInt(b []byte, value int64) []byte {
+	if value >= 0 {
+		if value <= 0x7f { // int
+			return append(b, byte(value))
+		} else if value <= 0xff { // uint
+			return append(b, 0xcc, uint8(value))
+		} else if value <= 0x7fff { // int
+			return appendUint16(append(b, 0xd1), uint16(value))
+		} else if value <= 0xffff { // uint
+			return appendUint16(append(b, 0xcd), uint16(value))
+		} else if value <= 0x7fffffff { // int
+			return appendUint32(append(b, 0xd2), uint32(value))
+		} else if value <= 0xffffffff { // uint
+			return appendUint32(append(b, 0xce), uint32(value))
+		}
+		return appendUint64(append(b, 0xd3), uint64(value)) // int
+	}
+
+	if value >= -32 {
+		return append(b, byte(value))
+	} else if value >= -0x80 {
+		return append(b, 0xd0, byte(value))
+	} else if value >= -0x8000 {
+		return appendUint16(append(b, 0xd1), uint16(value))
+	} else if value >= -0x80000000 {
+		return appendUint32(append(b, 0xd2), uint32(value))
+	}
+	return appendUint64(append(b, 0xd3), uint64(value))
+}
+
+// AppendUint appends an unsigned integer value to 'b'.
+func AppendUint(b []byte, value uint64) []byte {
+	if value <= 0xff {
+		return append(b, 0xcc, uint8(value))
+	} else if value <= 0xffff {
+		return appendUint16(append(b, 0xcd), uint16(value))
+	} else if value <= 0xffffffff {
+		return appendUint32(append(b, 0xce), uint32(value))
+	}
+	return appendUint64(append(b, 0xcf), value)
+}
+
+// AppendFloat32 appends a float32 value to 'b'.
+func AppendFloat32(b []byte, value float32) []byte {
+	return appendUint32(append(b, 0xca), math.Float32bits(value))
+}
+
+// AppendFloat64 appends a float64 value to 'b'.
+func AppendFloat64(b []byte, value float64) []byte {
+	return appendUint64(append(b, 0xcb), math.Float64bits(value))
+}
+
+// AppendString appends a string value to 'b'.
+func AppendString(b []byte, value string) []byte {
+	len := len(value)
+	if len <= 0x1f {
+		b = append(b, 0xa0|uint8(len))
+	} else if len <= 0xff {
+		b = append(b, 0xd9, uint8(len))
+	} else if len <= 0xffff {
+		b = appendUint16(append(b, 0xda), uint16(len))
+	} else {
+		b = appendUint32(append(b, 0xdb), uint32(checkLen(len)))
+	}
+	return append(b, value...)
+}
+
+// AppendBin appends a byte slice as a value of the bin format family to 'b'.
+func AppendBin(b []byte, value []byte) []byte {
+	len := len(value)
+	if len <= 0xff {
+		b = append(b, 0xc4, uint8(len))
+	} else if len <= 0xffff {
+		b = appendUint16(append(b, 0xc5), uint16(len))
+	} else {
+		b = appendUint32(append(b, 0xc6), uint32(checkLen(len)))
+	}
+	return append(b, value...)
+}
+
+// AppendArrayHeader appends the header of an array with 'size' elements to 'b'.
+// The elements should be appended after the header.
+func AppendArrayHeader(b []byte, size int) []byte {
+	return appendHeader(b, size, 0x90, 0xdc, 0xdd)
+}
+
+// AppendMapHeader appends the header of a map with 'size' key-value pairs to 'b'.
+// The keys and values should be appended alternately after the header.
+func AppendMapHeader(b []byte, size int) []byte {
+	return appendHeader(b, size, 0x80, 0xde, 0xdf)
+}
+
+func appendHeader(b []byte, size int, fix, head16, head32 byte) []byte {
+	if uint64(size) <= 0x0f {
+		return append(b, fix|uint8(size))
+	} else if uint64(size) <= 0xffff {
+		return appendUint16(append(b, head16), uint16(size))
+	}
+	return appendUint32(append(b, head32), uint32(checkLen(size)))
+}
+
+// AppendExtension appends an extension value to 'b'.
+// The smallest format of the ext format family is chosen for the length of data.
+func AppendExtension(b []byte, ext Extension) []byte {
+	len := len(ext.Data)
+	switch len {
+	case 1:
+		b = append(b, 0xd4)
+	case 2:
+		b = append(b, 0xd5)
+	case 4:
+		b = append(b, 0xd6)
+	case 8:
+		b = append(b, 0xd7)
+	case 16:
+		b = append(b, 0xd8)
+	default:
+		if len <= 0xff {
+			b = append(b, 0xc7, uint8(len))
+		} else if len <= 0xffff {
+			b = appendUint16(append(b, 0xc8), uint16(len))
+		} else {
+			b = appendUint32(append(b, 0xc9), uint32(checkLen(len)))
+		}
+	}
+	b = append(b, byte(ext.Type))
+	return append(b, ext.Data...)
+}
+
+// checkLen panics if the length cannot be represented in msgpack.
+// The Pack functions check the lengths before calling the Append functions and return errors.
+func checkLen(len int) int {
+	if uint64(len) > math.MaxUint32 {
+		panic("msgp: length exceeds the limit of msgpack")
+	}
+	return len
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return append(b, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
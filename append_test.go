@@ -0,0 +1,91 @@
+package msgp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func ExampleAppendInt() {
+	b := make([]byte, 0, 64)
+
+	b = AppendMapHeader(b, 2)
+	b = AppendString(b, "id")
+	b = AppendInt(b, 300)
+	b = AppendString(b, "ok")
+	b = AppendBool(b, true)
+	fmt.Printf("% x\n", b)
+
+	// Output:
+	// 82 a2 69 64 d1 01 2c a2 6f 6b c3
+}
+
+func TestAppendMatchesPack(t *testing.T) {
+	ints := []int64{0, 0x7f, 0x80, 0xff, 0x100, 0x7fff, 0x8000, 0xffff, 0x10000, 0x7fffffff, 0x80000000,
+		0xffffffff, 0x100000000, -1, -32, -33, -0x80, -0x81, -0x8000, -0x8001, -0x80000000, -0x80000001}
+	for _, v := range ints {
+		checkAppend(t, fmt.Sprintf("int %d", v), AppendInt(nil, v), func(w io.Writer) error { return PackInt(w, v) })
+		u := uint64(v)
+		checkAppend(t, fmt.Sprintf("uint %d", u), AppendUint(nil, u), func(w io.Writer) error { return PackUint(w, u) })
+	}
+
+	for _, n := range []int{0, 0x1f, 0x20, 0xff, 0x100, 0xffff, 0x10000} {
+		s := strings.Repeat("a", n)
+		checkAppend(t, fmt.Sprintf("string %d", n), AppendString(nil, s), func(w io.Writer) error { return PackString(w, s) })
+		checkAppend(t, fmt.Sprintf("bin %d", n), AppendBin(nil, []byte(s)), func(w io.Writer) error { return PackBin(w, []byte(s)) })
+		ext := Extension{5, []byte(s)}
+		checkAppend(t, fmt.Sprintf("ext %d", n), AppendExtension(nil, ext), func(w io.Writer) error { return PackExtension(w, ext) })
+		checkAppend(t, fmt.Sprintf("array %d", n), AppendArrayHeader(nil, n), func(w io.Writer) error { return PackArrayHeader(w, n) })
+		checkAppend(t, fmt.Sprintf("map %d", n), AppendMapHeader(nil, n), func(w io.Writer) error { return PackMapHeader(w, n) })
+	}
+
+	checkAppend(t, "nil", AppendNil(nil), PackNil)
+	checkAppend(t, "bool", AppendBool(nil, false), func(w io.Writer) error { return PackBool(w, false) })
+	checkAppend(t, "float32", AppendFloat32(nil, 1.5), func(w io.Writer) error { return PackFloat32(w, 1.5) })
+	checkAppend(t, "float64", AppendFloat64(nil, 1.5), func(w io.Writer) error { return PackFloat64(w, 1.5) })
+}
+
+func checkAppend(t *testing.T, name string, got []byte, pack func(w io.Writer) error) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := pack(&buf); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if !bytes.Equal(got, buf.Bytes()) {
+		t.Errorf("%s: appended % x, packed % x", name, got, buf.Bytes())
+	}
+}
+
+func TestAppendDoesNotAllocate(t *testing.T) {
+	b := make([]byte, 0, 1024)
+	data := []byte("data")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		b = b[:0]
+		b = AppendArrayHeader(b, 6)
+		b = AppendInt(b, -100000)
+		b = AppendUint(b, 100000)
+		b = AppendFloat64(b, 1.5)
+		b = AppendString(b, "string")
+		b = AppendBin(b, data)
+		b = AppendExtension(b, Extension{1, data})
+	})
+	if allocs != 0 {
+		t.Errorf("allocs = %v, want 0", allocs)
+	}
+}
+
+func TestEncoderPrimitivesDoNotAllocate(t *testing.T) {
+	enc := NewEncoder(io.Discard)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		PackInt(enc, -100000)
+		PackString(enc, "string")
+	})
+	if allocs != 0 {
+		t.Errorf("allocs = %v, want 0", allocs)
+	}
+}
@@ -0,0 +1,198 @@
+package msgp
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// In canonical mode, an Encoder produces the same bytes for the same values:
+//
+//   - The keys of maps are sorted by their packed values in this order:
+//     nil, false, true, numbers, strings, bins, and the others.
+//     Numbers are ordered by value regardless of their types; an integer comes before a float
+//     of the same value. Strings and bins are ordered bytewise. The others, such as arrays and
+//     extensions, are ordered by their packed bytes.
+//   - Unsigned integers which fit in int64 are packed as signed integers, so that a number
+//     has the same encoding regardless of its Go type.
+//   - A float64 value which is exactly representable as a float32 is packed as a float32.
+//     NaN is packed as the float32 quiet NaN.
+//
+// The bytes written by Marshaler, StreamMarshaler and Raw values are written as they are.
+
+const (
+	keyNil = iota
+	keyBool
+	keyNumber
+	keyString
+	keyBin
+	keyOther
+)
+
+type mapEntry struct {
+	key   []byte // packed key
+	value reflect.Value
+}
+
+// packMapCanonical writes a map with the keys sorted by their packed values.
+func packMapCanonical(e *Encoder, m reflect.Value) error {
+	var err error
+
+	if err = PackMapHeader(e, m.Len()); err != nil {
+		return err
+	}
+
+	// pack the keys into the buffer of the Encoder, and move them out.
+	base := len(e.buf)
+	entries := make([]mapEntry, 0, m.Len())
+	ends := make([]int, 0, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		if err = packValue(e, iter.Key()); err != nil {
+			return err
+		}
+		entries = append(entries, mapEntry{value: iter.Value()})
+		ends = append(ends, len(e.buf))
+	}
+	keys := append([]byte(nil), e.buf[base:]...)
+	e.buf = e.buf[:base]
+
+	start := 0
+	for inx := range entries {
+		end := ends[inx] - base
+		entries[inx].key = keys[start:end:end]
+		start = end
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return compareKeys(entries[i].key, entries[j].key) < 0
+	})
+
+	for _, entry := range entries {
+		e.buf = append(e.buf, entry.key...)
+		if err = packValue(e, entry.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareKeys compares two packed keys in the canonical order.
+func compareKeys(a, b []byte) int {
+	ca, cb := keyClass(a[0]), keyClass(b[0])
+	if ca != cb {
+		return ca - cb
+	}
+
+	c := 0
+	switch ca {
+	case keyNumber:
+		x, _, _ := readNumberBytes(a, nil)
+		y, _, _ := readNumberBytes(b, nil)
+		c = compareNumbers(x, y)
+	case keyString, keyBin:
+		x, _, _ := readDataBytes(a, nil, true)
+		y, _, _ := readDataBytes(b, nil, true)
+		c = bytes.Compare(x, y)
+	}
+	if c == 0 {
+		c = bytes.Compare(a, b)
+	}
+	return c
+}
+
+func keyClass(head byte) int {
+	switch {
+	case head == 0xc0:
+		return keyNil
+	case head == 0xc2 || head == 0xc3:
+		return keyBool
+	case head <= 0x7f || head >= 0xe0 || (head >= 0xca && head <= 0xd3):
+		return keyNumber
+	case head&0xe0 == 0xa0 || (head >= 0xd9 && head <= 0xdb):
+		return keyString
+	case head >= 0xc4 && head <= 0xc6:
+		return keyBin
+	}
+	return keyOther
+}
+
+// compareNumbers compares two numbers by value. An integer comes before a float of the same value,
+// and NaN comes before all numbers.
+func compareNumbers(x, y number) int {
+	if x.kind != numFloat && y.kind != numFloat {
+		xneg, yneg := x.kind == numInt && x.i < 0, y.kind == numInt && y.i < 0
+		if xneg != yneg {
+			if xneg {
+				return -1
+			}
+			return 1
+		}
+		if xneg {
+			return compareInt64(x.i, y.i)
+		}
+		return compareUint64(x.unsigned(), y.unsigned())
+	}
+
+	fx, fy := x.float(), y.float()
+	switch {
+	case math.IsNaN(fx) || math.IsNaN(fy):
+		if math.IsNaN(fx) && math.IsNaN(fy) {
+			return 0
+		} else if math.IsNaN(fx) {
+			return -1
+		}
+		return 1
+	case fx < fy:
+		return -1
+	case fx > fy:
+		return 1
+	case x.kind != y.kind: // an integer and a float of the same value
+		if x.kind == numFloat {
+			return 1
+		}
+		return -1
+	}
+	return 0
+}
+
+func compareInt64(x, y int64) int {
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+func compareUint64(x, y uint64) int {
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+func (n number) unsigned() uint64 {
+	if n.kind == numInt {
+		return uint64(n.i)
+	}
+	return n.u
+}
+
+func (n number) float() float64 {
+	switch n.kind {
+	case numInt:
+		return float64(n.i)
+	case numUint:
+		return float64(n.u)
+	}
+	return n.f
+}
+
+// isCanonical reports whether w is an Encoder in canonical mode.
+func isCanonical(w interface{}) bool {
+	e, ok := w.(*Encoder)
+	return ok && e.canonical
+}
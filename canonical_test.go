@@ -0,0 +1,172 @@
+package msgp
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func ExampleEncoder_UseCanonicalEncoding() {
+	var buf bytes.Buffer
+
+	enc := NewEncoder(&buf)
+	enc.UseCanonicalEncoding(true)
+	enc.Encode(map[interface{}]interface{}{
+		"b": uint64(1), "a": 1.5, 10: nil, -1: true, false: 2.5, nil: "x",
+	})
+	fmt.Printf("% x\n", buf.Bytes())
+
+	// Output:
+	// 86 c0 a1 78 c2 ca 40 20 00 00 ff c3 0a c0 a1 61 ca 3f c0 00 00 a1 62 01
+}
+
+func TestCanonicalDeterministic(t *testing.T) {
+	type item struct {
+		Name  string
+		Attrs map[string]int
+	}
+	m := make(map[string]item)
+	for inx := 0; inx < 100; inx++ {
+		attrs := make(map[string]int)
+		for jnx := 0; jnx < 10; jnx++ {
+			attrs[fmt.Sprint("attr", jnx)] = jnx
+		}
+		m[fmt.Sprint("key", inx)] = item{Name: fmt.Sprint(inx), Attrs: attrs}
+	}
+
+	var first []byte
+	for run := 0; run < 20; run++ {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.UseCanonicalEncoding(true)
+		if err := enc.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+		if run == 0 {
+			first = buf.Bytes()
+		} else if !bytes.Equal(buf.Bytes(), first) {
+			t.Fatalf("run %d: output differs", run)
+		}
+	}
+
+	var out map[string]item
+	if err := Unpack(bytes.NewReader(first), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 100 || out["key7"].Attrs["attr3"] != 3 {
+		t.Errorf("unexpected result: %v", out["key7"])
+	}
+}
+
+func TestCanonicalKeyOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []interface{}
+	}{
+		{"ints", []interface{}{int64(math.MinInt64), -129, -1, 0, 1, 127, 128, 65536, uint64(math.MaxUint64)}},
+		{"mixed numbers", []interface{}{math.NaN(), -1.5, -1, 0, 0.5, 1, 1.5, uint8(2), 2.5}},
+		{"int before float", []interface{}{3, 3.0}},
+		{"strings", []interface{}{"", "A", "B", "a", "ab", "b"}},
+		{"types", []interface{}{nil, false, true, -5, 7, "s", [1]int{1}}},
+	}
+
+	for _, test := range tests {
+		// pack the keys in the expected order.
+		want := AppendMapHeader(nil, len(test.keys))
+		for inx, key := range test.keys {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.UseCanonicalEncoding(true)
+			if err := enc.Encode(key); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, buf.Bytes()...)
+			want = AppendInt(want, int64(inx))
+		}
+
+		// insert the keys in the reversed order.
+		m := make(map[interface{}]int)
+		for inx := len(test.keys) - 1; inx >= 0; inx-- {
+			m[test.keys[inx]] = inx
+		}
+
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.UseCanonicalEncoding(true)
+		if err := enc.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s:\n got % x\nwant % x", test.name, buf.Bytes(), want)
+		}
+	}
+}
+
+func TestCanonicalCompareKeys(t *testing.T) {
+	keys := [][]byte{
+		AppendNil(nil),
+		AppendBool(nil, false),
+		AppendBool(nil, true),
+		AppendFloat64(nil, math.NaN()),
+		AppendInt(nil, -1),
+		AppendFloat32(nil, -0.5),
+		AppendUint(nil, 0),
+		AppendInt(nil, 1),
+		AppendFloat64(nil, 1),
+		AppendUint(nil, math.MaxUint64),
+		AppendString(nil, ""),
+		AppendString(nil, "a"),
+		AppendBin(nil, []byte{}),
+		AppendBin(nil, []byte("a")),
+		AppendArrayHeader(nil, 0),
+	}
+	for i := range keys {
+		for j := range keys {
+			c := compareKeys(keys[i], keys[j])
+			if (i < j && c >= 0) || (i > j && c <= 0) || (i == j && c != 0) {
+				t.Errorf("compareKeys(% x, % x) = %d", keys[i], keys[j], c)
+			}
+		}
+	}
+}
+
+func TestCanonicalSmallestEncoding(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  []byte
+	}{
+		{uint(5), []byte{0x05}},
+		{uint64(200), []byte{0xcc, 0xc8}},
+		{uint32(300), []byte{0xd1, 0x01, 0x2c}},
+		{uint64(math.MaxUint64), []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{1.5, []byte{0xca, 0x3f, 0xc0, 0x00, 0x00}},
+		{0.1, []byte{0xcb, 0x3f, 0xb9, 0x99, 0x99, 0x99, 0x99, 0x99, 0x9a}},
+		{math.Inf(1), []byte{0xca, 0x7f, 0x80, 0x00, 0x00}},
+		{math.Float64frombits(0x7ff8000000000001), []byte{0xca, 0x7f, 0xc0, 0x00, 0x00}},
+		{float32(2), []byte{0xca, 0x40, 0x00, 0x00, 0x00}},
+		{math.Float32frombits(0xffc00001), []byte{0xca, 0x7f, 0xc0, 0x00, 0x00}},
+		{[]uint16{1, 256}, []byte{0x92, 0x01, 0xd1, 0x01, 0x00}},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.UseCanonicalEncoding(true)
+		if err := enc.Encode(test.value); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), test.want) {
+			t.Errorf("%T(%v): got % x, want % x", test.value, test.value, buf.Bytes(), test.want)
+		}
+	}
+
+	// without the option, the formats follow the Go types.
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(uint(5)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{0xcc, 0x05}) {
+		t.Errorf("got % x", buf.Bytes())
+	}
+}
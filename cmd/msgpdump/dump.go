@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/shanpark/msgp"
+)
+
+const (
+	maxStringLen = 64 // longer strings are truncated in the output.
+	maxBinLen    = 16 // longer bin and ext data are truncated in the output.
+	maxDepth     = 10000
+)
+
+// dump writes the annotated tree of the values read from the io.Reader. The values are
+// printed one by one as they arrive, so a live stream is printed without waiting for its end.
+func dump(w io.Writer, r io.Reader) error {
+	rec := &recorder{r: r}
+	dec := newDecoder(rec)
+
+	fmt.Fprintf(w, "offset  byte  format\n")
+	d := &dumper{w: w}
+	for {
+		var raw msgp.Raw
+		if err := dec.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return d.dumpRest(rec, err)
+		}
+
+		d.data = raw
+		if _, err := d.value(raw, 0); err != nil {
+			return err
+		}
+		d.base += len(raw)
+		rec.buf = rec.buf[len(raw):]
+	}
+}
+
+// dumpRest prints the rest of the input as far as it goes, after the Decoder failed to
+// read a value with 'err'. The error found by the dumper tells the offset and the format.
+func (d *dumper) dumpRest(rec *recorder, err error) error {
+	if _, rerr := ioutil.ReadAll(rec); rerr != nil {
+		return err
+	}
+
+	d.data = rec.buf
+	for rest := d.data; len(rest) > 0; {
+		var derr error
+		if rest, derr = d.value(rest, 0); derr != nil {
+			return derr
+		}
+	}
+	return err
+}
+
+// recorder keeps the bytes read from the input after the beginning of the current value.
+type recorder struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (rec *recorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	rec.buf = append(rec.buf, p[:n]...)
+	return n, err
+}
+
+type dumper struct {
+	w    io.Writer
+	data []byte // the input from the current value to calculate offsets.
+	base int    // offset of 'data' in the input.
+}
+
+// value prints the value at the beginning of 'b' and its elements, and returns the remaining bytes.
+func (d *dumper) value(b []byte, depth int) ([]byte, error) {
+	var err error
+	var rest []byte
+	var info string
+
+	offset := d.base + len(d.data) - len(b)
+	head := b[0]
+	name := msgp.FormatName(head)
+	if depth > maxDepth {
+		return b, fmt.Errorf("offset 0x%06x: nesting depth exceeds %d", offset, maxDepth)
+	}
+
+	switch family(head) {
+	case "nil":
+		_, rest, err = msgp.ReadNilBytes(b)
+	case "bool":
+		_, rest, err = msgp.ReadBoolBytes(b) // the format name tells the value.
+	case "int":
+		var v int64
+		v, rest, err = msgp.ReadIntBytes(b)
+		info = fmt.Sprint(v)
+	case "uint":
+		var v uint64
+		v, rest, err = msgp.ReadUintBytes(b)
+		info = fmt.Sprint(v)
+	case "float":
+		var v float64
+		v, rest, err = msgp.ReadFloatBytes(b)
+		info = fmt.Sprint(v)
+	case "str":
+		var v string
+		v, rest, err = msgp.ReadStringBytes(b)
+		info = fmt.Sprintf("len=%d %s", len(v), quote(v))
+	case "bin":
+		var v []byte
+		v, rest, err = msgp.ReadBinBytes(b)
+		info = fmt.Sprintf("len=%d %s", len(v), hexBytes(v))
+	case "ext":
+		var v msgp.Extension
+		v, rest, err = msgp.ReadExtensionBytes(b)
+		info = fmt.Sprintf("type=%d len=%d %s", v.Type, len(v.Data), hexBytes(v.Data))
+		if err == nil && v.Type == msgp.TimestampExtType {
+			var t time.Time
+			if msgp.Unpack(bytes.NewReader(b[:len(b)-len(rest)]), &t) == nil {
+				info += " " + t.Format(time.RFC3339Nano)
+			}
+		}
+	case "array":
+		var size int
+		if size, rest, err = msgp.ReadArrayHeaderBytes(b); err == nil {
+			d.line(offset, head, depth, name, fmt.Sprintf("len=%d", size))
+			return d.elements(rest, size, depth)
+		}
+	case "map":
+		var size int
+		if size, rest, err = msgp.ReadMapHeaderBytes(b); err == nil {
+			d.line(offset, head, depth, name, fmt.Sprintf("len=%d", size))
+			return d.elements(rest, size*2, depth)
+		}
+	default:
+		err = fmt.Errorf("msgp: unknown format[0x%02x] was found", head)
+	}
+
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return b, fmt.Errorf("offset 0x%06x: %s: %v", offset, name, err)
+	}
+	d.line(offset, head, depth, name, info)
+	return rest, nil
+}
+
+// elements prints 'n' values following the header of an array or a map.
+func (d *dumper) elements(b []byte, n int, depth int) ([]byte, error) {
+	var err error
+
+	for inx := 0; inx < n; inx++ {
+		if len(b) == 0 {
+			return b, fmt.Errorf("offset 0x%06x: %v", d.base+len(d.data), io.ErrUnexpectedEOF)
+		}
+		if b, err = d.value(b, depth+1); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+func (d *dumper) line(offset int, head byte, depth int, name, info string) {
+	if info != "" {
+		name += " " + info
+	}
+	fmt.Fprintf(d.w, "%06x  %02x    %s%s\n", offset, head, strings.Repeat("  ", depth), name)
+}
+
+func quote(s string) string {
+	if len(s) > maxStringLen {
+		return fmt.Sprintf("%q...", s[:maxStringLen])
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+func hexBytes(b []byte) string {
+	if len(b) > maxBinLen {
+		return fmt.Sprintf("% x ...", b[:maxBinLen])
+	}
+	return fmt.Sprintf("% x", b)
+}
+
+// family returns the format family of the format.
+func family(head byte) string {
+	switch {
+	case head <= 0x7f || head >= 0xe0 || (head >= 0xd0 && head <= 0xd3):
+		return "int"
+	case head <= 0x8f || head == 0xde || head == 0xdf:
+		return "map"
+	case head <= 0x9f || head == 0xdc || head == 0xdd:
+		return "array"
+	case head <= 0xbf || (head >= 0xd9 && head <= 0xdb):
+		return "str"
+	case head == 0xc0:
+		return "nil"
+	case head == 0xc2 || head == 0xc3:
+		return "bool"
+	case head >= 0xc4 && head <= 0xc6:
+		return "bin"
+	case (head >= 0xc7 && head <= 0xc9) || (head >= 0xd4 && head <= 0xd8):
+		return "ext"
+	case head == 0xca || head == 0xcb:
+		return "float"
+	case head >= 0xcc && head <= 0xcf:
+		return "uint"
+	}
+	return ""
+}
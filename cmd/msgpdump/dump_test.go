@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shanpark/msgp"
+)
+
+func TestDump(t *testing.T) {
+	var buf bytes.Buffer
+	msgp.PackMapHeader(&buf, 2)
+	msgp.PackString(&buf, "id")
+	msgp.PackUint(&buf, 300)
+	msgp.PackString(&buf, "tags")
+	msgp.Pack(&buf, []interface{}{-1, 1.5, []byte{1, 2}, nil, true})
+	msgp.Pack(&buf, time.Unix(1, 0))
+	msgp.PackExtension(&buf, msgp.Extension{Type: 5, Data: []byte{9}})
+
+	var out bytes.Buffer
+	if err := dump(&out, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `offset  byte  format
+000000  82    fixmap len=2
+000001  a2      fixstr len=2 "id"
+000004  cd      uint16 300
+000007  a4      fixstr len=4 "tags"
+00000c  95      fixarray len=5
+00000d  ff        negative fixint -1
+00000e  cb        float64 1.5
+000017  c4        bin8 len=2 01 02
+00001b  c0        nil
+00001c  c3        true
+00001d  d6    fixext4 type=-1 len=4 00 00 00 01 1970-01-01T00:00:01Z
+000023  d4    fixext1 type=5 len=1 09
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestDumpErrors(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{[]byte{0x92, 0x01}, "offset 0x000002: unexpected EOF"},
+		{[]byte{0xcd, 0x01}, "offset 0x000000: uint16: unexpected EOF"},
+		{[]byte{0x01, 0x91, 0xcd, 0x01}, "offset 0x000002: uint16: unexpected EOF"},
+		{[]byte{0x91, 0xc1}, "offset 0x000001: (never used): msgp: unknown format[0xc1] was found"},
+	}
+	for _, test := range tests {
+		err := dump(&bytes.Buffer{}, bytes.NewReader(test.data))
+		if err == nil || err.Error() != test.want {
+			t.Errorf("dump(% x): got error %v, want %q", test.data, err, test.want)
+		}
+	}
+}
+
+// chanWriter sends each write to a channel.
+type chanWriter chan string
+
+func (cw chanWriter) Write(p []byte) (int, error) {
+	cw <- string(p)
+	return len(p), nil
+}
+
+func TestDumpLiveStream(t *testing.T) {
+	pr, pw := io.Pipe()
+	out := make(chanWriter, 16)
+	done := make(chan error)
+	go func() {
+		done <- dump(out, pr)
+	}()
+
+	wait := func(want string) {
+		for {
+			select {
+			case got := <-out:
+				if strings.Contains(got, want) {
+					return
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%q is not printed before the end of the stream", want)
+			}
+		}
+	}
+
+	pw.Write([]byte{0x92, 0x01})
+	pw.Write([]byte{0x02})
+	wait("000002  02      positive fixint 2")
+	pw.Write([]byte{0xa1, 'x'})
+	wait(`000003  a1    fixstr len=1 "x"`)
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := run(&out, bytes.NewReader([]byte{0x92, 0x01, 0xc3}), true); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "[1,true]" {
+		t.Errorf("got %s", got)
+	}
+}
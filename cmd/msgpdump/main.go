@@ -0,0 +1,96 @@
+// Command msgpdump prints msgpack data in a human readable form.
+// It reads a single value or a stream of concatenated values from a file or stdin,
+// and prints an indented tree annotated with the offset, the format byte and
+// the format name of each value, along with the lengths and the values.
+// Each value is printed as soon as it arrives, so a live stream can be watched.
+//
+// Usage:
+//
+//	msgpdump [-json] [file]
+//
+// For example, the bytes 82 a2 69 64 cd 01 2c a3 74 61 67 c0 are printed as:
+//
+//	offset  byte  format
+//	000000  82    fixmap len=2
+//	000001  a2      fixstr len=2 "id"
+//	000004  cd      uint16 300
+//	000007  a3      fixstr len=3 "tag"
+//	00000b  c0      nil
+//
+// With the -json flag, each value is printed as a line of JSON text instead,
+// as converted by msgp.ToJSON.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/shanpark/msgp"
+)
+
+func main() {
+	asJSON := flag.Bool("json", false, "print each value as a line of JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: msgpdump [flags] [file]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var r io.Reader = os.Stdin
+	if flag.NArg() == 1 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "msgpdump: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	err := run(w, flushingReader{r, w}, *asJSON)
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "msgpdump: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(w io.Writer, r io.Reader, asJSON bool) error {
+	if asJSON {
+		return msgp.ToJSON(w, newDecoder(r))
+	}
+	return dump(w, r)
+}
+
+// newDecoder returns a Decoder reading ahead from r, without the limits on the lengths
+// of the values, which is fine for inspecting data.
+func newDecoder(r io.Reader) *msgp.Decoder {
+	dec := msgp.NewDecoder(r)
+	dec.SetLimits(msgp.Limits{MaxContainerLen: -1, MaxStringLen: -1, MaxBytes: -1})
+	return dec
+}
+
+// flushingReader flushes the output before it reads the input, so that the values
+// are printed as soon as they arrive from a live stream.
+type flushingReader struct {
+	r io.Reader
+	w *bufio.Writer
+}
+
+func (fr flushingReader) Read(p []byte) (int, error) {
+	if err := fr.w.Flush(); err != nil {
+		return 0, err
+	}
+	return fr.r.Read(p)
+}
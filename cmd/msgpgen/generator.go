@@ -0,0 +1,962 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shanpark/msgp"
+	"github.com/shanpark/msgp/internal/tags"
+)
+
+const msgpImportPath = "github.com/shanpark/msgp"
+
+type fieldKind int
+
+const (
+	kindOther     fieldKind = iota // packed with msgp.Pack and unpacked with msgp.Unpack
+	kindBool                       // bool and the types defined on it
+	kindInt                        // signed integer types
+	kindUint                       // unsigned integer types
+	kindFloat32                    // float32
+	kindFloat64                    // float64
+	kindString                     // string
+	kindBin                        // []byte
+	kindStruct                     // struct type generated together
+	kindPtrStruct                  // pointer to a struct type generated together
+)
+
+var builtinKinds = map[string]fieldKind{
+	"bool":    kindBool,
+	"int":     kindInt,
+	"int8":    kindInt,
+	"int16":   kindInt,
+	"int32":   kindInt,
+	"int64":   kindInt,
+	"rune":    kindInt,
+	"uint":    kindUint,
+	"uint8":   kindUint,
+	"uint16":  kindUint,
+	"uint32":  kindUint,
+	"uint64":  kindUint,
+	"byte":    kindUint,
+	"float32": kindFloat32,
+	"float64": kindFloat64,
+	"string":  kindString,
+}
+
+// hookMethods are the methods which change the way the msgp package packs or unpacks a type.
+// The types with one of them are always packed and unpacked with msgp.Pack and msgp.Unpack.
+var hookMethods = map[string]bool{
+	"MarshalMsgpack":   true,
+	"UnmarshalMsgpack": true,
+	"EncodeMsgpack":    true,
+	"DecodeMsgpack":    true,
+	"MarshalBinary":    true,
+	"UnmarshalBinary":  true,
+	"MarshalText":      true,
+	"UnmarshalText":    true,
+}
+
+type field struct {
+	goName    string   // Go name of the field
+	name      string   // packed name of the field
+	expr      ast.Expr // type of the field
+	kind      fieldKind
+	omitEmpty bool
+	omitZero  bool
+	asString  bool
+	required  bool
+	tagged    bool
+
+	defaultLit string // Go expression of the value of the default option, e.g. "Port(8080)"
+}
+
+type structType struct {
+	name    string
+	fields  []*field
+	asArray bool
+}
+
+type generator struct {
+	pkgName string
+	tagName string
+	specs   map[string]*ast.TypeSpec // type declarations of the file
+	hooks   map[string]bool          // types with hook methods
+	targets map[string]bool          // struct types to be generated
+	structs []*structType
+	imports map[string]bool
+	naming  string // value of the -naming flag
+}
+
+// namingStrategies are the naming strategies selected by the -naming flag,
+// with the names of the variables in the msgp package.
+var namingStrategies = map[string]struct {
+	strategy *msgp.NamingStrategy
+	name     string
+}{
+	"snake": {msgp.SnakeCase, "SnakeCase"},
+	"camel": {msgp.CamelCase, "CamelCase"},
+	"kebab": {msgp.KebabCase, "KebabCase"},
+	"lower": {msgp.LowerCase, "LowerCase"},
+}
+
+// newGenerator parses the source of the input file, and the sources of the other files of
+// the package in 'pkgFiles' by their names for the declarations of types and hook methods.
+func newGenerator(filename string, src []byte, pkgFiles map[string][]byte, tagName, naming string, typeNames []string) (*generator, error) {
+	if _, ok := namingStrategies[naming]; naming != "" && !ok {
+		return nil, fmt.Errorf("unknown naming strategy %q", naming)
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &generator{
+		pkgName: file.Name.Name,
+		tagName: tagName,
+		naming:  naming,
+		specs:   map[string]*ast.TypeSpec{},
+		hooks:   map[string]bool{},
+		targets: map[string]bool{},
+	}
+
+	fileSpecs := g.collectDecls(file)
+	for name, pkgSrc := range pkgFiles {
+		pkgFile, err := parser.ParseFile(fset, name, pkgSrc, 0)
+		if err != nil {
+			return nil, err
+		}
+		if pkgFile.Name.Name == g.pkgName {
+			g.collectDecls(pkgFile)
+		}
+	}
+
+	var structSpecs []*ast.TypeSpec
+	for _, spec := range fileSpecs {
+		if _, ok := spec.Type.(*ast.StructType); ok && !spec.Assign.IsValid() {
+			structSpecs = append(structSpecs, spec)
+		}
+	}
+
+	if len(typeNames) == 0 {
+		for _, spec := range structSpecs {
+			g.targets[spec.Name.Name] = true
+		}
+	} else {
+		for _, name := range typeNames {
+			name = strings.TrimSpace(name)
+			var spec *ast.TypeSpec
+			for _, fs := range fileSpecs {
+				if fs.Name.Name == name {
+					spec = fs
+				}
+			}
+			if spec == nil {
+				return nil, fmt.Errorf("type %s is not found in %s", name, filename)
+			}
+			if _, ok := spec.Type.(*ast.StructType); !ok || spec.Assign.IsValid() {
+				return nil, fmt.Errorf("type %s is not a struct type", name)
+			}
+			g.targets[name] = true
+		}
+	}
+
+	for _, spec := range structSpecs {
+		if !g.targets[spec.Name.Name] {
+			continue
+		}
+		st, err := g.parseStruct(spec.Name.Name, spec.Type.(*ast.StructType))
+		if err != nil {
+			return nil, err
+		}
+		g.structs = append(g.structs, st)
+	}
+	if len(g.structs) == 0 {
+		return nil, fmt.Errorf("no struct type is found in %s", filename)
+	}
+	return g, nil
+}
+
+// collectDecls collects the type declarations and the types with hook methods of a file,
+// and returns the type declarations of the file.
+func (g *generator) collectDecls(file *ast.File) []*ast.TypeSpec {
+	var specs []*ast.TypeSpec
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if spec, ok := spec.(*ast.TypeSpec); ok {
+					g.specs[spec.Name.Name] = spec
+					specs = append(specs, spec)
+				}
+			}
+		case *ast.FuncDecl:
+			if decl.Recv != nil && len(decl.Recv.List) == 1 && hookMethods[decl.Name.Name] {
+				recv := decl.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+				if id, ok := recv.(*ast.Ident); ok {
+					g.hooks[id.Name] = true
+				}
+			}
+		}
+	}
+	return specs
+}
+
+// parseStruct collects the fields of a struct type with the same rules as the msgp package.
+func (g *generator) parseStruct(name string, typ *ast.StructType) (*structType, error) {
+	st := &structType{name: name}
+
+	for _, f := range typ.Fields.List {
+		var tag string
+		if f.Tag != nil {
+			raw, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			tag = reflect.StructTag(raw).Get(g.tagName)
+		}
+
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("embedded field %s in %s is not supported", types.ExprString(f.Type), name)
+		}
+
+		for _, id := range f.Names {
+			tagName, opts := tags.Parse(tag)
+			if id.Name == "_" {
+				if opts.Contains("asarray") {
+					st.asArray = true
+				}
+				continue
+			}
+			if !ast.IsExported(id.Name) {
+				continue
+			}
+
+			fd := &field{goName: id.Name, expr: f.Type, kind: g.classify(f.Type, true), tagged: tagName != ""}
+			if tag == "" {
+				fd.name = g.fieldName(id.Name)
+			} else if tagName == "-" {
+				if strings.TrimSpace(string(opts)) == "" {
+					continue
+				}
+				fd.name = "_"
+			} else {
+				fd.name = tagName
+				if fd.name == "" {
+					fd.name = g.fieldName(id.Name)
+				}
+				fd.omitEmpty = opts.Contains("omitempty")
+				fd.omitZero = opts.Contains("omitzero")
+				fd.asString = opts.Contains("string")
+				fd.required = opts.Contains("required")
+				if value, ok := opts.Value("default"); ok {
+					lit, err := defaultLiteral(fd, value)
+					if err != nil {
+						return nil, fmt.Errorf("default option of field %s.%s: %v", name, id.Name, err)
+					}
+					fd.defaultLit = lit
+				}
+			}
+
+			if fd.asString {
+				switch fd.kind {
+				case kindBool, kindInt, kindUint, kindFloat32, kindFloat64:
+				default:
+					return nil, fmt.Errorf("string option is not supported for field %s.%s", name, id.Name)
+				}
+			}
+			st.fields = append(st.fields, fd)
+		}
+	}
+
+	st.fields = dominantFields(st.fields)
+	return st, nil
+}
+
+// fieldName returns the packed name of a field without a name in the tag.
+func (g *generator) fieldName(goName string) string {
+	if g.naming == "" {
+		return goName
+	}
+	return namingStrategies[g.naming].strategy.FieldName(goName)
+}
+
+// defaultLiteral returns the Go expression of the value of the default option,
+// converted with the same rules as the msgp package.
+func defaultLiteral(f *field, value string) (string, error) {
+	var lit string
+	null := value == "nil" || value == "null"
+
+	switch f.kind {
+	case kindBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil && !null {
+			return "", err
+		}
+		lit = strconv.FormatBool(b)
+	case kindInt:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil && !null {
+			return "", err
+		}
+		lit = strconv.FormatInt(i, 10)
+	case kindUint:
+		u, err := strconv.ParseUint(value, 10, 64)
+		if err != nil && !null {
+			return "", err
+		}
+		lit = strconv.FormatUint(u, 10)
+	case kindFloat32, kindFloat64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil && !null {
+			return "", err
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", fmt.Errorf("%v is not supported", f)
+		}
+		lit = strconv.FormatFloat(f, 'g', -1, 64)
+	case kindString:
+		if null {
+			value = ""
+		}
+		lit = strconv.Quote(value)
+	default:
+		return "", fmt.Errorf("not supported for the type %s", types.ExprString(f.expr))
+	}
+	return types.ExprString(f.expr) + "(" + lit + ")", nil
+}
+
+// dominantFields removes the fields with the same packed name.
+// A field with a name given by the tag wins. Otherwise, all of them are removed.
+func dominantFields(fields []*field) []*field {
+	byName := map[string][]*field{}
+	for _, f := range fields {
+		byName[f.name] = append(byName[f.name], f)
+	}
+
+	var out []*field
+	for _, f := range fields {
+		same := byName[f.name]
+		if len(same) == 1 {
+			out = append(out, f)
+			continue
+		}
+		sort.SliceStable(same, func(i, j int) bool { return same[i].tagged && !same[j].tagged })
+		if same[0] == f && same[0].tagged != same[1].tagged {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// classify returns the kind of a field type.
+// 'named' is false when the type is the underlying type of a defined type.
+func (g *generator) classify(expr ast.Expr, named bool) fieldKind {
+	switch t := expr.(type) {
+	case *ast.ParenExpr:
+		return g.classify(t.X, named)
+	case *ast.Ident:
+		spec := g.specs[t.Name]
+		if spec == nil {
+			return builtinKinds[t.Name]
+		}
+		if spec.Assign.IsValid() { // alias
+			return g.classify(spec.Type, named)
+		}
+		if g.hooks[t.Name] {
+			return kindOther
+		}
+		if g.targets[t.Name] {
+			if named {
+				return kindStruct
+			}
+			return kindOther
+		}
+		kind := g.classify(spec.Type, false)
+		if kind == kindStruct || kind == kindPtrStruct {
+			return kindOther
+		}
+		return kind
+	case *ast.ArrayType:
+		if id, ok := t.Elt.(*ast.Ident); ok && t.Len == nil && g.specs[id.Name] == nil {
+			if id.Name == "byte" || id.Name == "uint8" {
+				return kindBin
+			}
+		}
+	case *ast.StarExpr:
+		if id, ok := t.X.(*ast.Ident); ok && named && g.targets[id.Name] && !g.hooks[id.Name] {
+			return kindPtrStruct
+		}
+	}
+	return kindOther
+}
+
+// underlying returns the underlying type expression of the types declared in the file.
+func (g *generator) underlying(expr ast.Expr) ast.Expr {
+	for {
+		switch t := expr.(type) {
+		case *ast.ParenExpr:
+			expr = t.X
+			continue
+		case *ast.Ident:
+			if spec := g.specs[t.Name]; spec != nil {
+				expr = spec.Type
+				continue
+			}
+		}
+		return expr
+	}
+}
+
+// emptyCond returns the condition that the field is empty with the rules of encoding/json.
+// It returns "" if the field is never empty.
+func (g *generator) emptyCond(f *field) string {
+	v := "z." + f.goName
+	switch f.kind {
+	case kindBool:
+		return "!" + v
+	case kindInt, kindUint, kindFloat32, kindFloat64:
+		return v + " == 0"
+	case kindString:
+		return v + ` == ""`
+	case kindBin:
+		return "len(" + v + ") == 0"
+	case kindStruct:
+		return ""
+	case kindPtrStruct:
+		return v + " == nil"
+	}
+
+	switch t := g.underlying(f.expr).(type) {
+	case *ast.StarExpr, *ast.InterfaceType, *ast.FuncType, *ast.ChanType:
+		return v + " == nil"
+	case *ast.MapType, *ast.ArrayType:
+		return "len(" + v + ") == 0"
+	case *ast.StructType:
+		return ""
+	case *ast.Ident:
+		switch builtinKinds[t.Name] {
+		case kindBool:
+			return "!" + v
+		case kindString:
+			return v + ` == ""`
+		case kindOther:
+		default:
+			return v + " == 0"
+		}
+	}
+	return "msgp.IsEmpty(&" + v + ")"
+}
+
+// zeroCond returns the condition that the field is zero with the rules of the omitzero option.
+// Only the predeclared types are checked inline, because any other type may have an IsZero method.
+func (g *generator) zeroCond(f *field) string {
+	v := "z." + f.goName
+	if id, ok := f.expr.(*ast.Ident); ok && g.specs[id.Name] == nil {
+		switch builtinKinds[id.Name] {
+		case kindBool:
+			return "!" + v
+		case kindString:
+			return v + ` == ""`
+		case kindOther:
+		default:
+			return v + " == 0"
+		}
+	}
+	return "msgp.IsZero(&" + v + ")"
+}
+
+// omitCond returns the condition that the field is omitted, or "" if it is never omitted.
+func (g *generator) omitCond(f *field) string {
+	var conds []string
+	if f.omitEmpty {
+		if cond := g.emptyCond(f); cond != "" {
+			conds = append(conds, cond)
+		}
+	}
+	if f.omitEmpty && f.defaultLit != "" {
+		conds = append(conds, "(omitDefault && z."+f.goName+" == "+f.defaultLit+")")
+	}
+	if f.omitZero {
+		if cond := g.zeroCond(f); len(conds) == 0 || conds[0] != cond {
+			conds = append(conds, cond)
+		}
+	}
+	return strings.Join(conds, " || ")
+}
+
+// negate returns the negation of a condition made by omitCond.
+func negate(cond string) string {
+	if strings.Contains(cond, " || ") || strings.Contains(cond, " && ") {
+		return "!(" + cond + ")"
+	}
+	if strings.HasPrefix(cond, "!") {
+		return cond[1:]
+	}
+	if strings.Contains(cond, " == ") {
+		return strings.Replace(cond, " == ", " != ", 1)
+	}
+	return "!" + cond
+}
+
+func (g *generator) generate() ([]byte, error) {
+	var body bytes.Buffer
+	g.imports = map[string]bool{"io": true}
+
+	for _, st := range g.structs {
+		g.writeMarshal(&body, st)
+		g.writeUnmarshal(&body, st)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by msgpgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", g.pkgName)
+	fmt.Fprintf(&buf, "import (\n")
+	var imports []string
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	for _, path := range imports {
+		fmt.Fprintf(&buf, "\t%q\n", path)
+	}
+	fmt.Fprintf(&buf, "\n\t%q\n)\n", msgpImportPath)
+	buf.Write(body.Bytes())
+
+	return format.Source(buf.Bytes())
+}
+
+func (g *generator) writeMarshal(buf *bytes.Buffer, st *structType) {
+	fmt.Fprintf(buf, "\n// MarshalMsg writes z to w as a msgpack value.\n")
+	fmt.Fprintf(buf, "// The value is buffered and written at once, or not at all if an error occurs.\n")
+	fmt.Fprintf(buf, "func (z *%s) MarshalMsg(w io.Writer) error {\n", st.name)
+	fmt.Fprintf(buf, "return msgp.EncodeTo(w, func(w *msgp.Encoder) error {\n")
+	fmt.Fprintf(buf, "var err error\n\n")
+
+	if st.asArray {
+		g.writeMarshalArray(buf, st)
+		fmt.Fprintf(buf, "})\n}\n")
+		return
+	}
+
+	fmt.Fprintf(buf, "if w.ArrayEncodedStructs() {\n")
+	g.writeMarshalArray(buf, st)
+	fmt.Fprintf(buf, "}\n\n")
+
+	for _, f := range st.fields {
+		if f.omitEmpty && f.defaultLit != "" {
+			fmt.Fprintf(buf, "omitDefault := w.DefaultAsEmpty()\n\n")
+			break
+		}
+	}
+
+	fmt.Fprintf(buf, "size := %d\n", len(st.fields))
+	conds := make([]string, len(st.fields))
+	for inx, f := range st.fields {
+		conds[inx] = g.omitCond(f)
+		if conds[inx] != "" {
+			fmt.Fprintf(buf, "if %s {\nsize--\n}\n", conds[inx])
+		}
+	}
+	fmt.Fprintf(buf, "if err = msgp.PackMapHeader(w, size); err != nil {\nreturn err\n}\n")
+
+	for inx, f := range st.fields {
+		if conds[inx] != "" {
+			fmt.Fprintf(buf, "if %s {\n", negate(conds[inx]))
+		}
+		writeCheck(buf, fmt.Sprintf("err = msgp.PackString(w, %q)", f.name))
+		writeCheck(buf, g.packStmt(f))
+		if conds[inx] != "" {
+			fmt.Fprintf(buf, "}\n")
+		}
+	}
+	fmt.Fprintf(buf, "return nil\n})\n}\n")
+}
+
+// writeMarshalArray writes the code packing the fields as an array in field order.
+func (g *generator) writeMarshalArray(buf *bytes.Buffer, st *structType) {
+	fmt.Fprintf(buf, "if err = msgp.PackArrayHeader(w, %d); err != nil {\nreturn err\n}\n", len(st.fields))
+	for _, f := range st.fields {
+		writeCheck(buf, g.packStmt(f))
+	}
+	fmt.Fprintf(buf, "return nil\n")
+}
+
+// writeCheck writes a statement assigning err and the check of err.
+func writeCheck(buf *bytes.Buffer, stmt string) {
+	if strings.HasPrefix(stmt, "err = ") && !strings.Contains(stmt, "\n") {
+		fmt.Fprintf(buf, "if %s; err != nil {\nreturn err\n}\n", stmt)
+		return
+	}
+	fmt.Fprintf(buf, "%s\nif err != nil {\nreturn err\n}\n", stmt)
+}
+
+func (g *generator) packStmt(f *field) string {
+	v := "z." + f.goName
+
+	if f.asString {
+		g.imports["strconv"] = true
+		switch f.kind {
+		case kindBool:
+			return fmt.Sprintf("err = msgp.PackString(w, strconv.FormatBool(bool(%s)))", v)
+		case kindInt:
+			return fmt.Sprintf("err = msgp.PackString(w, strconv.FormatInt(int64(%s), 10))", v)
+		case kindUint:
+			return fmt.Sprintf("err = msgp.PackString(w, strconv.FormatUint(uint64(%s), 10))", v)
+		case kindFloat32:
+			return fmt.Sprintf("err = msgp.PackString(w, strconv.FormatFloat(float64(%s), 'g', -1, 32))", v)
+		case kindFloat64:
+			return fmt.Sprintf("err = msgp.PackString(w, strconv.FormatFloat(float64(%s), 'g', -1, 64))", v)
+		}
+	}
+
+	switch f.kind {
+	case kindBool:
+		return fmt.Sprintf("err = msgp.PackBool(w, bool(%s))", v)
+	case kindInt:
+		return fmt.Sprintf("err = msgp.PackInt(w, int64(%s))", v)
+	case kindUint:
+		return fmt.Sprintf("err = msgp.PackUint(w, uint64(%s))", v)
+	case kindFloat32:
+		return fmt.Sprintf("err = msgp.PackFloat32(w, float32(%s))", v)
+	case kindFloat64:
+		return fmt.Sprintf("err = msgp.PackFloat64(w, float64(%s))", v)
+	case kindString:
+		return fmt.Sprintf("err = msgp.PackString(w, string(%s))", v)
+	case kindBin:
+		return fmt.Sprintf("err = msgp.PackBin(w, []byte(%s))", v)
+	case kindStruct:
+		return fmt.Sprintf("err = %s.MarshalMsg(w)", v)
+	case kindPtrStruct:
+		return fmt.Sprintf("if %s == nil {\nerr = msgp.PackNil(w)\n} else {\nerr = %s.MarshalMsg(w)\n}", v, v)
+	}
+	return fmt.Sprintf("err = msgp.Pack(w, &%s)", v)
+}
+
+func (g *generator) writeUnmarshal(buf *bytes.Buffer, st *structType) {
+	g.imports["reflect"] = true
+	g.imports["strconv"] = true
+
+	var required, tracked []*field // tracked: the fields to be seen for the required and default options
+	for _, f := range st.fields {
+		if f.required {
+			required = append(required, f)
+		}
+		if f.required || f.defaultLit != "" {
+			tracked = append(tracked, f)
+		}
+	}
+
+	fmt.Fprintf(buf, "\n// UnmarshalMsg reads a msgpack value from r into z.\n")
+	fmt.Fprintf(buf, "// The value can be a map or an array, as msgp.Unpack accepts. A nil value sets the zero value.\n")
+	fmt.Fprintf(buf, "func (z *%s) UnmarshalMsg(r io.Reader) error {\n", st.name)
+	fmt.Fprintf(buf, "d := msgp.DecoderOf(r)\n\n")
+	fmt.Fprintf(buf, "if isNil, err := d.ReadNil(); err != nil {\nreturn err\n} else if isNil {\n*z = %s{}\nreturn nil\n}\n\n", st.name)
+	fmt.Fprintf(buf, "size, isArray, err := d.ReadStructHeader()\nif err != nil {\nreturn err\n}\n")
+	fmt.Fprintf(buf, "if err = d.Enter(); err != nil {\nreturn err\n}\ndefer d.Leave()\n\n")
+	fmt.Fprintf(buf, "if !d.MergeMode() {\n*z = %s{}\n}\n\n", st.name)
+
+	fmt.Fprintf(buf, "if isArray {\nfor inx := 0; inx < size; inx++ {\nswitch inx {\n")
+	for inx, f := range st.fields {
+		fmt.Fprintf(buf, "case %d:\n%s\n", inx, g.unpackStmt(f))
+	}
+	fmt.Fprintf(buf, "default:\nerr = d.Skip()\n}\nif err != nil {\nreturn err\n}\n}\n")
+	fmt.Fprintf(buf, "if d.StrictMode() && size > %d {\nvar unknown []string\n", len(st.fields))
+	fmt.Fprintf(buf, "for inx := %d; inx < size; inx++ {\nunknown = append(unknown, strconv.Itoa(inx))\n}\n", len(st.fields))
+	fmt.Fprintf(buf, "return &msgp.UnknownFieldsError{Type: reflect.TypeOf(z).Elem(), Keys: unknown}\n}\n")
+	if len(tracked) > len(required) {
+		fmt.Fprintf(buf, "if !d.MergeMode() {\n")
+		for inx, f := range st.fields {
+			if f.defaultLit != "" {
+				fmt.Fprintf(buf, "if size <= %d {\nz.%s = %s\n}\n", inx, f.goName, f.defaultLit)
+			}
+		}
+		fmt.Fprintf(buf, "}\n")
+	}
+	if len(required) > 0 {
+		fmt.Fprintf(buf, "var missing []string\n")
+		for inx, f := range st.fields {
+			if f.required {
+				fmt.Fprintf(buf, "if size <= %d {\nmissing = append(missing, %q)\n}\n", inx, f.name)
+			}
+		}
+		writeMissingCheck(buf)
+	}
+	fmt.Fprintf(buf, "return nil\n}\n\n")
+
+	fmt.Fprintf(buf, "var unknown []string\n")
+	if len(tracked) > 0 {
+		fmt.Fprintf(buf, "var seen [%d]bool\n", len(tracked))
+	}
+	fmt.Fprintf(buf, "for inx := 0; inx < size; inx++ {\nvar key string\nvar isStr bool\n")
+	fmt.Fprintf(buf, "if key, isStr, err = d.ReadStructKey(); err != nil {\nreturn err\n}\n")
+	fmt.Fprintf(buf, "if !isStr {\nif d.StrictMode() {\nunknown = append(unknown, key)\n}\nif err = d.Skip(); err != nil {\nreturn err\n}\ncontinue\n}\n\n")
+	g.writeFoldKey(buf, st)
+	fmt.Fprintf(buf, "switch key {\n")
+	for _, f := range st.fields {
+		fmt.Fprintf(buf, "case %q:\n", f.name)
+		for inx, r := range tracked {
+			if r == f {
+				fmt.Fprintf(buf, "seen[%d] = true\n", inx)
+			}
+		}
+		fmt.Fprintf(buf, "%s\n", g.unpackStmt(f))
+	}
+	fmt.Fprintf(buf, "default:\nif d.StrictMode() {\nunknown = append(unknown, key)\n}\nerr = d.Skip()\n}\n")
+	fmt.Fprintf(buf, "if err != nil {\nreturn err\n}\n}\n")
+	fmt.Fprintf(buf, "if unknown != nil {\nreturn &msgp.UnknownFieldsError{Type: reflect.TypeOf(z).Elem(), Keys: unknown}\n}\n")
+	if len(tracked) > len(required) {
+		fmt.Fprintf(buf, "if !d.MergeMode() {\n")
+		for inx, f := range tracked {
+			if f.defaultLit != "" {
+				fmt.Fprintf(buf, "if !seen[%d] {\nz.%s = %s\n}\n", inx, f.goName, f.defaultLit)
+			}
+		}
+		fmt.Fprintf(buf, "}\n")
+	}
+	if len(required) > 0 {
+		fmt.Fprintf(buf, "var missing []string\n")
+		for inx, f := range tracked {
+			if f.required {
+				fmt.Fprintf(buf, "if !seen[%d] {\nmissing = append(missing, %q)\n}\n", inx, f.name)
+			}
+		}
+		writeMissingCheck(buf)
+	}
+	fmt.Fprintf(buf, "return nil\n}\n")
+}
+
+// writeFoldKey writes the code replacing a key with the name of the field which matches it
+// case-insensitively, if the Decoder is in that mode and no field has the exact name.
+func (g *generator) writeFoldKey(buf *bytes.Buffer, st *structType) {
+	if len(st.fields) == 0 {
+		return
+	}
+	g.imports["strings"] = true
+
+	names := make([]string, len(st.fields))
+	for inx, f := range st.fields {
+		names[inx] = strconv.Quote(f.name)
+	}
+	fmt.Fprintf(buf, "if d.CaseInsensitiveKeys() {\nswitch key {\ncase %s:\ndefault:\n", strings.Join(names, ", "))
+	fmt.Fprintf(buf, "switch strings.ToLower(key) {\n")
+	folded := map[string]bool{}
+	for _, f := range st.fields {
+		lower := strings.ToLower(f.name)
+		if !folded[lower] {
+			folded[lower] = true
+			fmt.Fprintf(buf, "case %q:\nkey = %q\n", lower, f.name)
+		}
+	}
+	fmt.Fprintf(buf, "}\n}\n}\n\n")
+}
+
+func writeMissingCheck(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "if missing != nil {\nreturn &msgp.MissingFieldsError{Type: reflect.TypeOf(z).Elem(), Keys: missing}\n}\n")
+}
+
+func (g *generator) unpackStmt(f *field) string {
+	v := "z." + f.goName
+	typ := types.ExprString(f.expr)
+
+	if f.asString {
+		g.imports["strconv"] = true
+		var parse string
+		switch f.kind {
+		case kindBool:
+			parse = "var v bool\nv, err = strconv.ParseBool(s)"
+		case kindInt:
+			parse = "var v int64\nv, err = strconv.ParseInt(s, 10, 64)"
+		case kindUint:
+			parse = "var v uint64\nv, err = strconv.ParseUint(s, 10, 64)"
+		case kindFloat32, kindFloat64:
+			parse = "var v float64\nv, err = strconv.ParseFloat(s, 64)"
+		}
+		return fmt.Sprintf("var s string\nif s, err = d.ReadString(); err == nil && s != \"nil\" && s != \"null\" {\n%s\n%s = %s(v)\n}", parse, v, typ)
+	}
+
+	var read string
+	switch f.kind {
+	case kindBool:
+		read = "var v bool\nv, err = d.ReadBool()"
+	case kindInt:
+		read = "var v int64\nv, err = d.ReadInt()"
+	case kindUint:
+		read = "var v uint64\nv, err = d.ReadUint()"
+	case kindFloat32, kindFloat64:
+		read = "var v float64\nv, err = d.ReadFloat()"
+	case kindString:
+		read = "var v string\nv, err = d.ReadString()"
+	case kindBin:
+		read = "var v []byte\nv, err = d.ReadBin()"
+	case kindStruct:
+		return fmt.Sprintf("err = %s.UnmarshalMsg(d)", v)
+	case kindPtrStruct:
+		elem := types.ExprString(f.expr.(*ast.StarExpr).X)
+		return fmt.Sprintf("var isNil bool\nif isNil, err = d.ReadNil(); err == nil && isNil {\n%s = nil\n} else if err == nil {\nif %s == nil || !d.MergeMode() {\n%s = new(%s)\n}\nerr = %s.UnmarshalMsg(d)\n}", v, v, v, elem, v)
+	default:
+		return fmt.Sprintf("err = msgp.Unpack(d, &%s)", v)
+	}
+	return fmt.Sprintf("%s\n%s = %s(v)", read, v, typ)
+}
+
+func (g *generator) generateTests() ([]byte, error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "// Code generated by msgpgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", g.pkgName)
+	fmt.Fprintf(&buf, "import (\n\"bytes\"\n\"errors\"\n\"testing\"\n\n%q\n)\n", msgpImportPath)
+
+	pack, unpack, newEncoder := "msgp.Pack(&ref, &v)", "msgp.Unpack(bytes.NewReader(gen.Bytes()), &refOut)", "msgp.NewEncoder"
+	if g.naming != "" {
+		buf.WriteString(strings.Replace(namingHelpers, "$N", namingStrategies[g.naming].name, -1))
+		pack, unpack, newEncoder = "msgpgenEncoder(&ref).Encode(&v)", "msgpgenDecoder(gen.Bytes()).Decode(&refOut)", "msgpgenEncoder"
+	}
+	test := strings.NewReplacer("$PACK", pack, "$UNPACK", unpack, "$NEWENC", newEncoder).Replace(roundTripTest)
+
+	for _, st := range g.structs {
+		fmt.Fprintf(&buf, "\nfunc TestMarshalMsg%s(t *testing.T) {\n", st.name)
+		fmt.Fprintf(&buf, "v := %s{", st.name)
+		for _, f := range st.fields {
+			if sample := sampleValue(f); sample != "" {
+				fmt.Fprintf(&buf, "\n%s: %s,", f.goName, sample)
+			}
+		}
+		fmt.Fprintf(&buf, "\n}\n\n")
+		buf.WriteString(strings.Replace(test, "$T", st.name, -1))
+	}
+
+	return format.Source(buf.Bytes())
+}
+
+// roundTripTest is the body of the test generated for a type $T.
+const roundTripTest = `var gen, ref bytes.Buffer
+if err := v.MarshalMsg(&gen); err != nil {
+t.Fatal(err)
+}
+if err := $PACK; err != nil {
+t.Fatal(err)
+}
+if !bytes.Equal(gen.Bytes(), ref.Bytes()) {
+t.Fatalf("MarshalMsg and msgp.Pack differ:\n% x\n% x", gen.Bytes(), ref.Bytes())
+}
+
+genAllocs := testing.AllocsPerRun(100, func() {
+gen.Reset()
+v.MarshalMsg(&gen)
+})
+refAllocs := testing.AllocsPerRun(100, func() {
+ref.Reset()
+$PACK
+})
+if genAllocs > refAllocs {
+t.Errorf("MarshalMsg allocates more than msgp.Pack: %v > %v", genAllocs, refAllocs)
+}
+
+var out, refOut $T
+if err := out.UnmarshalMsg(bytes.NewReader(gen.Bytes())); err != nil {
+t.Fatal(err)
+}
+if err := $UNPACK; err != nil {
+t.Fatal(err)
+}
+
+for _, u := range []*$T{&out, &refOut} {
+var again bytes.Buffer
+if err := u.MarshalMsg(&again); err != nil {
+t.Fatal(err)
+}
+if !bytes.Equal(again.Bytes(), gen.Bytes()) {
+t.Fatalf("round trip differs:\n% x\n% x", again.Bytes(), gen.Bytes())
+}
+}
+
+var genArr, refArr bytes.Buffer
+genEnc, refEnc := $NEWENC(&genArr), $NEWENC(&refArr)
+genEnc.UseArrayEncodedStructs(true)
+refEnc.UseArrayEncodedStructs(true)
+if err := v.MarshalMsg(genEnc); err != nil {
+t.Fatal(err)
+}
+if err := refEnc.Encode(&v); err != nil {
+t.Fatal(err)
+}
+if !bytes.Equal(genArr.Bytes(), refArr.Bytes()) {
+t.Fatalf("MarshalMsg and Encode differ for array-encoded structs:\n% x\n% x", genArr.Bytes(), refArr.Bytes())
+}
+
+deep := msgp.NewDecoder(bytes.NewReader(gen.Bytes()))
+deep.SetLimits(msgp.Limits{MaxDepth: 1})
+if err := deep.Enter(); err != nil {
+t.Fatal(err)
+}
+var le *msgp.LimitError
+if err := new($T).UnmarshalMsg(deep); !errors.As(err, &le) || le.Limit != "MaxDepth" {
+t.Fatalf("MaxDepth is not checked: %v", err)
+}
+
+fromNil, zero := v, $T{}
+if err := fromNil.UnmarshalMsg(bytes.NewReader([]byte{0xc0})); err != nil {
+t.Fatal(err)
+}
+var got, want bytes.Buffer
+if err := fromNil.MarshalMsg(&got); err != nil {
+t.Fatal(err)
+}
+if err := zero.MarshalMsg(&want); err != nil {
+t.Fatal(err)
+}
+if !bytes.Equal(got.Bytes(), want.Bytes()) {
+t.Fatalf("nil value is not unmarshalled into the zero value:\n% x\n% x", got.Bytes(), want.Bytes())
+}
+}
+`
+
+// namingHelpers are the functions generated for the tests to create the Encoder and
+// the Decoder with the naming strategy $N of the generated methods.
+const namingHelpers = `
+func msgpgenEncoder(b *bytes.Buffer) *msgp.Encoder {
+e := msgp.NewEncoder(b)
+e.SetNamingStrategy(msgp.$N)
+return e
+}
+
+func msgpgenDecoder(b []byte) *msgp.Decoder {
+d := msgp.NewDecoder(bytes.NewReader(b))
+d.SetNamingStrategy(msgp.$N)
+return d
+}
+`
+
+// sampleValue returns a non-zero value of the field for the tests.
+func sampleValue(f *field) string {
+	typ := types.ExprString(f.expr)
+	switch f.kind {
+	case kindBool:
+		return typ + "(true)"
+	case kindInt, kindUint:
+		return typ + "(1)"
+	case kindFloat32, kindFloat64:
+		return typ + "(1.5)"
+	case kindString:
+		return typ + `("a")`
+	case kindBin:
+		return typ + "{1, 2}"
+	}
+	return ""
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	src, err := ioutil.ReadFile("testdata/order.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgFiles, err := packageFiles("testdata/order.go", "testdata/order_msgp.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pkgFiles["status.go"]; !ok || len(pkgFiles) != 1 {
+		t.Fatalf("unexpected package files: %v", pkgFiles)
+	}
+
+	g, err := newGenerator("order.go", src, pkgFiles, "msgp", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := g.generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = parser.ParseFile(token.NewFileSet(), "order_msgp.go", code, 0); err != nil {
+		t.Fatalf("generated code doesn't parse: %v", err)
+	}
+	for _, want := range []string{
+		"func (z *Order) MarshalMsg(w io.Writer) error",
+		"func (z *Order) UnmarshalMsg(r io.Reader) error",
+		"msgp.PackArrayHeader(w, 2)",                                   // Point is packed as an array.
+		"if w.ArrayEncodedStructs() {",                                 // Order is packed as an array by the Encoder option.
+		`msgp.PackString(w, strconv.FormatInt(int64(z.ID), 10))`,       // string option
+		"if z.Quantity != 0 {",                                         // omitempty
+		"if len(z.Tags) != 0 {",                                        // omitempty of a slice
+		"if !msgp.IsZero(&z.Shipped) {",                                // omitzero
+		"err = msgp.Pack(w, &z.Items)",                                 // fallback to reflection
+		"err = msgp.Pack(w, &z.Status)",                                // MarshalText in another file
+		"err = z.Location.MarshalMsg(w)",                               // generated type
+		`case "created":` + "\n\t\t\terr = msgp.Unpack(d, &z.Created)", // type of other package
+		`case "name":` + "\n\t\t\tseen[0] = true",                      // required
+		"return &msgp.MissingFieldsError{",
+		"if d.StrictMode() {",
+		"if err = d.Enter(); err != nil {\n\t\treturn err\n\t}\n\tdefer d.Leave()", // limits
+		"if !d.MergeMode() {",
+		"} else if isNil {\n\t\t*z = Order{}\n\t\treturn nil", // nil value
+		"z.Rate = float32(0.5)",                               // default
+		`(omitDefault && z.Unit == string("pcs"))`,            // omitempty with default
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("generated code doesn't contain %q", want)
+		}
+	}
+	if strings.Contains(string(code), "Note") || strings.Contains(string(code), "internal") {
+		t.Error("skipped or unexported field is generated")
+	}
+
+	code, err = g.generateTests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(code), "func TestMarshalMsgOrder(t *testing.T)") {
+		t.Error("test for Order is not generated")
+	}
+}
+
+func TestGenerateOmitConds(t *testing.T) {
+	src := []byte(`package p
+
+import "time"
+
+type Level int
+
+type Names []string
+
+type Inner struct{ X int }
+
+type A struct {
+	B   bool              ` + "`msgp:\",omitempty\"`" + `
+	I   int8              ` + "`msgp:\",omitempty\"`" + `
+	F   float64           ` + "`msgp:\",omitempty\"`" + `
+	S   string            ` + "`msgp:\",omitempty\"`" + `
+	Bs  []byte            ` + "`msgp:\",omitempty\"`" + `
+	L   Level             ` + "`msgp:\",omitempty\"`" + `
+	N   Names             ` + "`msgp:\",omitempty\"`" + `
+	M   map[string]int    ` + "`msgp:\",omitempty\"`" + `
+	Arr [2]int            ` + "`msgp:\",omitempty\"`" + `
+	P   *int              ` + "`msgp:\",omitempty\"`" + `
+	Any interface{}       ` + "`msgp:\",omitempty\"`" + `
+	In  Inner             ` + "`msgp:\",omitempty\"`" + `
+	T   time.Time         ` + "`msgp:\",omitempty\"`" + `
+	Z1  int               ` + "`msgp:\",omitzero\"`" + `
+	Z2  Level             ` + "`msgp:\",omitzero\"`" + `
+	Z3  []int             ` + "`msgp:\",omitzero\"`" + `
+	Z4  time.Time         ` + "`msgp:\",omitempty,omitzero\"`" + `
+	Z5  string            ` + "`msgp:\",omitempty,omitzero\"`" + `
+}
+`)
+
+	g, err := newGenerator("p.go", src, nil, "msgp", "", []string{"A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"B":   "!z.B",
+		"I":   "z.I == 0",
+		"F":   "z.F == 0",
+		"S":   `z.S == ""`,
+		"Bs":  "len(z.Bs) == 0",
+		"L":   "z.L == 0",
+		"N":   "len(z.N) == 0",
+		"M":   "len(z.M) == 0",
+		"Arr": "len(z.Arr) == 0",
+		"P":   "z.P == nil",
+		"Any": "z.Any == nil",
+		"In":  "",
+		"T":   "msgp.IsEmpty(&z.T)",
+		"Z1":  "z.Z1 == 0",
+		"Z2":  "msgp.IsZero(&z.Z2)",
+		"Z3":  "msgp.IsZero(&z.Z3)",
+		"Z4":  "msgp.IsEmpty(&z.Z4) || msgp.IsZero(&z.Z4)",
+		"Z5":  `z.Z5 == ""`,
+	}
+	for _, f := range g.structs[0].fields {
+		if cond := g.omitCond(f); cond != want[f.goName] {
+			t.Errorf("%s: got %q, want %q", f.goName, cond, want[f.goName])
+		}
+	}
+	if _, err = g.generate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateDefault(t *testing.T) {
+	tests := []struct {
+		typ, value, want string
+	}{
+		{"bool", "true", "bool(true)"},
+		{"int8", "-3", "int8(-3)"},
+		{"uint", "nil", "uint(0)"},
+		{"float64", "1e3", "float64(1000)"},
+		{"string", "a\"b", `string("a\"b")`},
+		{"[]int", "1", ""},
+		{"int", "x", ""},
+		{"float32", "NaN", ""},
+	}
+
+	for _, test := range tests {
+		src := []byte("package p\n\ntype A struct {\n\tF " + test.typ + " `msgp:\"f,default=" + strings.Replace(test.value, `"`, `\"`, -1) + "\"`\n}\n")
+		g, err := newGenerator("p.go", src, nil, "msgp", "", nil)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s %q: no error", test.typ, test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q: %v", test.typ, test.value, err)
+			continue
+		}
+		if lit := g.structs[0].fields[0].defaultLit; lit != test.want {
+			t.Errorf("%s %q: got %s, want %s", test.typ, test.value, lit, test.want)
+		}
+	}
+}
+
+func TestGenerateTypes(t *testing.T) {
+	src := []byte(`package p
+
+type A struct {
+	X int
+	Y int ` + "`msgp:\"X\"`" + `
+	Z int ` + "`msgp:\"W\"`" + `
+	W int ` + "`msgp:\"W\"`" + `
+}
+
+type B struct {
+	A
+}
+`)
+
+	g, err := newGenerator("p.go", src, nil, "msgp", "", []string{"A"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.structs) != 1 {
+		t.Fatalf("unexpected structs: %d", len(g.structs))
+	}
+
+	var names []string
+	for _, f := range g.structs[0].fields {
+		names = append(names, f.goName)
+	}
+	if strings.Join(names, ",") != "Y" { // Y wins by the tag, and Z and W annihilate each other.
+		t.Errorf("unexpected fields: %v", names)
+	}
+
+	if _, err = newGenerator("p.go", src, nil, "msgp", "", nil); err == nil {
+		t.Error("embedded field is accepted")
+	}
+	if _, err = newGenerator("p.go", src, nil, "msgp", "", []string{"C"}); err == nil {
+		t.Error("unknown type is accepted")
+	}
+	other := map[string][]byte{"c.go": []byte("package p\n\ntype C struct{ X int }\n")}
+	if _, err = newGenerator("p.go", src, other, "msgp", "", []string{"C"}); err == nil {
+		t.Error("type of another file is accepted")
+	}
+}
+
+func TestGenerateNaming(t *testing.T) {
+	src := []byte(`package p
+
+type A struct {
+	UserID   int
+	HTTPHost string ` + "`msgp:\",omitempty\"`" + `
+	Name     string ` + "`msgp:\"NAME\"`" + `
+	Alias    string ` + "`msgp:\"name\"`" + `
+}
+`)
+
+	g, err := newGenerator("p.go", src, nil, "msgp", "snake", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range g.structs[0].fields {
+		names = append(names, f.name)
+	}
+	if strings.Join(names, ",") != "user_id,http_host,NAME,name" {
+		t.Errorf("unexpected names: %v", names)
+	}
+
+	code, err := g.generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`case "user_id", "http_host", "NAME", "name":`,
+		"switch strings.ToLower(key) {",
+		`case "name":` + "\n\t\t\t\t\tkey = \"NAME\"", // the first field wins.
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("generated code doesn't contain %q", want)
+		}
+	}
+	if strings.Contains(string(code), "key = \"name\"") {
+		t.Error("folded name is duplicated")
+	}
+
+	code, err = g.generateTests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(code), "e.SetNamingStrategy(msgp.SnakeCase)") {
+		t.Error("test doesn't use the naming strategy")
+	}
+
+	if _, err = newGenerator("p.go", src, nil, "msgp", "pascal", nil); err == nil {
+		t.Error("unknown naming strategy is accepted")
+	}
+}
@@ -0,0 +1,134 @@
+// Command msgpgen generates MarshalMsg and UnmarshalMsg methods for struct types,
+// so that the values of the types can be packed and unpacked without reflection.
+//
+// Usage:
+//
+//	msgpgen [-tag msgp] [-naming snake] [-type T1,T2] [-o output.go] [-tests=true] file.go
+//
+// msgpgen is go:generate friendly. With no file argument, it reads $GOFILE:
+//
+//	//go:generate msgpgen -type Order,Item
+//
+// The generated methods honor the same struct field tags as the msgp package
+// (name, "-", omitempty, omitzero, string, required and default), and produce the same bytes as msgp.Pack.
+// The strict, merge and case-insensitive modes of the Decoder are honored by the generated
+// UnmarshalMsg methods. The naming strategy is fixed at generation by the -naming flag; the
+// strategies set to an Encoder or a Decoder don't apply to the generated methods.
+// The fields of the types that msgpgen doesn't know, such as slices, maps and
+// types from other packages, are packed and unpacked with msgp.Pack and msgp.Unpack.
+// Embedded fields are not supported. The other files of the package in the directory are read
+// for the types declared in them and the methods, e.g. MarshalText, changing how msgp packs a type.
+//
+// By default, a test file checking the round trip of the generated methods
+// against msgp.Pack and msgp.Unpack is generated as well.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	tagName := flag.String("tag", "msgp", "name of the struct field tag")
+	naming := flag.String("naming", "", "naming strategy for the fields without a name in the tag: snake, camel, kebab or lower")
+	typeNames := flag.String("type", "", "comma-separated list of type names; default all struct types")
+	output := flag.String("o", "", "output file name; default <file>_msgp.go")
+	tests := flag.Bool("tests", true, "generate round-trip tests in <output>_test.go")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: msgpgen [flags] [file.go]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	input := flag.Arg(0)
+	if input == "" {
+		input = os.Getenv("GOFILE")
+	}
+	if input == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(input, ".go") + "_msgp.go"
+	}
+
+	var types []string
+	if *typeNames != "" {
+		types = strings.Split(*typeNames, ",")
+	}
+
+	if err := run(input, *output, *tagName, *naming, types, *tests); err != nil {
+		fmt.Fprintf(os.Stderr, "msgpgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(input, output, tagName, naming string, types []string, tests bool) error {
+	src, err := ioutil.ReadFile(input)
+	if err != nil {
+		return err
+	}
+
+	pkgFiles, err := packageFiles(input, output)
+	if err != nil {
+		return err
+	}
+
+	g, err := newGenerator(filepath.Base(input), src, pkgFiles, tagName, naming, types)
+	if err != nil {
+		return err
+	}
+
+	code, err := g.generate()
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(output, code, 0644); err != nil {
+		return err
+	}
+
+	if tests {
+		code, err = g.generateTests()
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(strings.TrimSuffix(output, ".go")+"_test.go", code, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packageFiles reads the other Go files of the package in the directory of the input file,
+// which match the build constraints, except the test files and the output file.
+func packageFiles(input, output string) (map[string][]byte, error) {
+	dir := filepath.Dir(input)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") ||
+			name == filepath.Base(input) || name == filepath.Base(output) {
+			continue
+		}
+		if ok, err := build.Default.MatchFile(dir, name); err != nil || !ok {
+			continue
+		}
+		src, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		files[name] = src
+	}
+	return files, nil
+}
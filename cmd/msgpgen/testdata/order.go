@@ -0,0 +1,41 @@
+package order
+
+import "time"
+
+type Status string
+
+type Flags []byte
+
+type Point struct {
+	_ struct{} `msgp:",asarray"`
+	X int
+	Y int
+}
+
+type Item struct {
+	Name     string   `msgp:"name,required"`
+	Price    float64  `msgp:"price"`
+	Quantity uint16   `msgp:"qty,omitempty"`
+	Tags     []string `msgp:",omitempty"`
+	Unit     string   `msgp:"unit,omitempty,default=pcs"`
+}
+
+type Order struct {
+	ID       int64   `msgp:"id,string"`
+	Status   Status  `msgp:"status"`
+	Paid     bool    `msgp:",omitempty"`
+	Rate     float32 `msgp:",default=0.5"`
+	Note     string  `msgp:"-"`
+	Raw      Flags
+	Items    []Item
+	Location Point
+	Parent   *Order    `msgp:",omitempty"`
+	Created  time.Time `msgp:"created"`
+	Shipped  time.Time `msgp:"shipped,omitzero"`
+	internal int
+}
+
+// Node refers to itself, so hostile input can nest it without bound.
+type Node struct {
+	Next *Node `msgp:"next"`
+}
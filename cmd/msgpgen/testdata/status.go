@@ -0,0 +1,15 @@
+package order
+
+import "strings"
+
+// The methods of Status are declared apart from the struct types,
+// so that msgpgen finds them only by reading the other files of the package.
+
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(strings.ToUpper(string(s))), nil
+}
+
+func (s *Status) UnmarshalText(text []byte) error {
+	*s = Status(strings.ToLower(string(text)))
+	return nil
+}
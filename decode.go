@@ -8,6 +8,7 @@ import (
 	"math"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
 // Unpack reads a value from the io.Reader. And assigns it to the value pointed by 'ptr'.
@@ -20,32 +21,51 @@ import (
 func Unpack(r io.Reader, ptr interface{}) error {
 	var err error
 
+	d := decoderOf(r)
+
+	if ok, err := unpackUnmarshaler(d, ptr); ok {
+		return err
+	}
+
 	wantType := reflect.TypeOf(ptr).Elem()
+	if wantType == extensionType {
+		return UnpackExtension(d, ptr)
+	}
+	if info := extInfoByType(wantType); info != nil {
+		return unpackRegisteredExt(d, info, ptr)
+	}
+
+	if !d.noEncodingMarshalers {
+		if ok, err := unpackEncodingUnmarshaler(d, ptr); ok {
+			return err
+		}
+	}
+
 	switch wantType.Kind() {
 	case reflect.Bool:
-		err = UnpackBool(r, ptr)
+		err = UnpackBool(d, ptr)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		err = UnpackInt(r, ptr)
+		err = UnpackInt(d, ptr)
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		err = UnpackUint(r, ptr)
+		err = UnpackUint(d, ptr)
 	case reflect.Float32, reflect.Float64:
-		err = UnpackFloat(r, ptr)
+		err = UnpackFloat(d, ptr)
 	case reflect.String:
-		err = UnpackString(r, ptr)
+		err = UnpackString(d, ptr)
 	case reflect.Array:
-		err = UnpackArray(r, ptr)
+		err = UnpackArray(d, ptr)
 	case reflect.Slice:
-		err = UnpackSlice(r, ptr)
+		err = UnpackSlice(d, ptr)
 	case reflect.Map:
-		err = UnpackMap(r, ptr)
+		err = UnpackMap(d, ptr)
 	case reflect.Struct:
-		err = UnpackStruct(r, ptr)
+		err = UnpackStruct(d, ptr)
 	case reflect.Ptr:
-		err = UnpackPtr(r, ptr)
+		err = UnpackPtr(d, ptr)
 	case reflect.Interface:
-		err = UnpackInterface(r, ptr)
+		err = UnpackInterface(d, ptr)
 	default:
-		return fmt.Errorf("msgp: specified type[%v] is not supported", wantType.Kind())
+		return &UnsupportedTypeError{Type: wantType}
 	}
 
 	return err
@@ -56,7 +76,14 @@ func UnpackBool(r io.Reader, ptr interface{}) error {
 	var err error
 	var val interface{}
 
-	if val, err = UnpackPrimitive(r); err != nil {
+	d := decoderOf(r)
+	head, err := d.peekByte()
+	if err != nil {
+		return err
+	}
+	offset := d.offset()
+
+	if val, err = UnpackPrimitive(d); err != nil {
 		return err
 	}
 
@@ -66,7 +93,7 @@ func UnpackBool(r io.Reader, ptr interface{}) error {
 		if b, ok := val.(bool); ok {
 			reflect.ValueOf(ptr).Elem().SetBool(b)
 		} else {
-			return fmt.Errorf("msgp: unpacked value[%v] is not assignable to bool type", val)
+			return typeError(head, offset, reflect.TypeOf(ptr).Elem(), "")
 		}
 	}
 	return nil
@@ -79,7 +106,14 @@ func UnpackInt(r io.Reader, ptr interface{}) error {
 	var err error
 	var val interface{}
 
-	if val, err = UnpackPrimitive(r); err != nil {
+	d := decoderOf(r)
+	head, err := d.peekByte()
+	if err != nil {
+		return err
+	}
+	offset := d.offset()
+
+	if val, err = UnpackPrimitive(d); err != nil {
 		return err
 	}
 
@@ -94,7 +128,7 @@ func UnpackInt(r io.Reader, ptr interface{}) error {
 		case reflect.Float32, reflect.Float64:
 			reflect.ValueOf(ptr).Elem().SetInt(int64(reflect.ValueOf(val).Float()))
 		default:
-			return fmt.Errorf("msgp: unpacked value[%v] is not assignable to integer type", val)
+			return typeError(head, offset, reflect.TypeOf(ptr).Elem(), "")
 		}
 	}
 	return nil
@@ -107,7 +141,14 @@ func UnpackUint(r io.Reader, ptr interface{}) error {
 	var err error
 	var val interface{}
 
-	if val, err = UnpackPrimitive(r); err != nil {
+	d := decoderOf(r)
+	head, err := d.peekByte()
+	if err != nil {
+		return err
+	}
+	offset := d.offset()
+
+	if val, err = UnpackPrimitive(d); err != nil {
 		return err
 	}
 
@@ -122,7 +163,7 @@ func UnpackUint(r io.Reader, ptr interface{}) error {
 		case reflect.Float32, reflect.Float64:
 			reflect.ValueOf(ptr).Elem().SetUint(uint64(reflect.ValueOf(val).Float()))
 		default:
-			return fmt.Errorf("msgp: unpacked value[%v] is not assignable to unsigned integer type", val)
+			return typeError(head, offset, reflect.TypeOf(ptr).Elem(), "")
 		}
 	}
 	return nil
@@ -135,7 +176,14 @@ func UnpackFloat(r io.Reader, ptr interface{}) error {
 	var err error
 	var val interface{}
 
-	if val, err = UnpackPrimitive(r); err != nil {
+	d := decoderOf(r)
+	head, err := d.peekByte()
+	if err != nil {
+		return err
+	}
+	offset := d.offset()
+
+	if val, err = UnpackPrimitive(d); err != nil {
 		return err
 	}
 
@@ -150,7 +198,7 @@ func UnpackFloat(r io.Reader, ptr interface{}) error {
 		case reflect.Float32, reflect.Float64:
 			reflect.ValueOf(ptr).Elem().SetFloat(reflect.ValueOf(val).Float())
 		default:
-			return fmt.Errorf("msgp: unpacked value[%v] is not assignable to float type", val)
+			return typeError(head, offset, reflect.TypeOf(ptr).Elem(), "")
 		}
 	}
 	return nil
@@ -161,7 +209,14 @@ func UnpackString(r io.Reader, ptr interface{}) error {
 	var err error
 	var val interface{}
 
-	if val, err = UnpackPrimitive(r); err != nil {
+	d := decoderOf(r)
+	head, err := d.peekByte()
+	if err != nil {
+		return err
+	}
+	offset := d.offset()
+
+	if val, err = UnpackPrimitive(d); err != nil {
 		return err
 	}
 
@@ -175,10 +230,10 @@ func UnpackString(r io.Reader, ptr interface{}) error {
 			if valTyp.Elem().Kind() == reflect.Uint8 {
 				reflect.ValueOf(ptr).Elem().SetString(string(val.([]byte)))
 			} else {
-				return fmt.Errorf("msgp: unpacked value[%v] is not assignable to string type", val)
+				return typeError(head, offset, reflect.TypeOf(ptr).Elem(), "")
 			}
 		} else {
-			return fmt.Errorf("msgp: unpacked value[%v] is not assignable to string type", val)
+			return typeError(head, offset, reflect.TypeOf(ptr).Elem(), "")
 		}
 	}
 	return nil
@@ -193,11 +248,14 @@ func UnpackArray(r io.Reader, ptr interface{}) error {
 	var err error
 	var head byte
 
+	d := decoderOf(r)
+
 	arrTyp := reflect.TypeOf(ptr).Elem()
 	arrVal := reflect.ValueOf(ptr).Elem()
 	arrLen := arrVal.Len()
 
-	if err = binary.Read(r, binary.BigEndian, &head); err != nil {
+	offset := d.offset()
+	if head, err = d.readByte(); err != nil {
 		return err
 	}
 
@@ -213,22 +271,26 @@ func UnpackArray(r io.Reader, ptr interface{}) error {
 
 			switch head {
 			case 0xc4:
-				byteSlice, err = unpackBin8(r)
+				byteSlice, err = unpackBin8(d)
 			case 0xc5:
-				byteSlice, err = unpackBin16(r)
+				byteSlice, err = unpackBin16(d)
 			case 0xc6:
-				byteSlice, err = unpackBin32(r)
+				byteSlice, err = unpackBin32(d)
 			}
 			if err != nil {
 				return err
 			}
 
-			arrVal.Set(reflect.Zero(reflect.ArrayOf(len(byteSlice), reflect.TypeOf(byteSlice).Elem())))
-			reflect.Copy(arrVal, reflect.ValueOf(byteSlice))
+			if arrLen < len(byteSlice) {
+				return typeError(head, offset, arrTyp, "array size is too small")
+			}
+
+			arrVal.Set(reflect.Zero(arrTyp))
+			copy(arrVal.Bytes(), byteSlice)
 			return nil
 		}
 
-		return fmt.Errorf("msgp: byte array can't be assigned to other type[%v] array", arrTyp.Elem().Kind())
+		return typeError(head, offset, arrTyp, "")
 	}
 
 	// handle array format family
@@ -237,28 +299,39 @@ func UnpackArray(r io.Reader, ptr interface{}) error {
 		srcLen = int(head & 0x0f)
 	} else if head == 0xdc {
 		var temp uint16
-		if err = binary.Read(r, binary.BigEndian, &temp); err != nil {
+		if temp, err = d.readUint16(); err != nil {
 			return err
 		}
 		srcLen = int(temp)
 	} else if head == 0xdd {
 		var temp uint32
-		if err = binary.Read(r, binary.BigEndian, &temp); err != nil {
+		if temp, err = d.readUint32(); err != nil {
 			return err
 		}
 		srcLen = int(temp) // maybe overflow.
 	} else {
-		return fmt.Errorf("msgp: unpacked value is not an array")
+		return typeError(head, offset, arrTyp, "")
 	}
 
 	if arrLen < srcLen {
-		return fmt.Errorf("msgp: array size is too small")
+		return typeError(head, offset, arrTyp, "array size is too small")
 	}
+	if err = d.enter(srcLen); err != nil {
+		return err
+	}
+	defer d.leave()
 
-	arrVal.Set(reflect.Zero(reflect.ArrayOf(arrLen, arrTyp.Elem()))) // array 생성.
+	if d.merge { // keep the elements to be read into, and zero the rest.
+		zero := reflect.Zero(arrTyp.Elem())
+		for inx := srcLen; inx < arrLen; inx++ {
+			arrVal.Index(inx).Set(zero)
+		}
+	} else {
+		arrVal.Set(reflect.Zero(arrTyp)) // array 생성.
+	}
 	for inx := 0; inx < srcLen; inx++ {
-		if err = Unpack(r, arrVal.Index(inx).Addr().Interface()); err != nil {
-			return err
+		if err = Unpack(d, arrVal.Index(inx).Addr().Interface()); err != nil {
+			return wrapField(err, fmt.Sprintf("[%d]", inx))
 		}
 	}
 	return nil
@@ -272,10 +345,13 @@ func UnpackSlice(r io.Reader, ptr interface{}) error {
 	var err error
 	var head byte
 
+	d := decoderOf(r)
+
 	sliceTyp := reflect.TypeOf(ptr).Elem()
 	sliceVal := reflect.ValueOf(ptr).Elem()
 
-	if err = binary.Read(r, binary.BigEndian, &head); err != nil {
+	offset := d.offset()
+	if head, err = d.readByte(); err != nil {
 		return err
 	}
 
@@ -291,21 +367,25 @@ func UnpackSlice(r io.Reader, ptr interface{}) error {
 
 			switch head {
 			case 0xc4:
-				byteSlice, err = unpackBin8(r)
+				byteSlice, err = unpackBin8(d)
 			case 0xc5:
-				byteSlice, err = unpackBin16(r)
+				byteSlice, err = unpackBin16(d)
 			case 0xc6:
-				byteSlice, err = unpackBin32(r)
+				byteSlice, err = unpackBin32(d)
 			}
 			if err != nil {
 				return err
 			}
 
-			sliceVal.Set(reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(byteSlice).Elem()), len(byteSlice), len(byteSlice)))
-			reflect.Copy(sliceVal, reflect.ValueOf(byteSlice))
+			if d.merge && !sliceVal.IsNil() && sliceVal.Cap() >= len(byteSlice) {
+				sliceVal.SetLen(len(byteSlice))
+			} else {
+				sliceVal.Set(reflect.MakeSlice(sliceTyp, len(byteSlice), len(byteSlice)))
+			}
+			copy(sliceVal.Bytes(), byteSlice)
 			return nil
 		}
-		return fmt.Errorf("msgp: byte array can't be assigned to other type[%v] slice", sliceTyp.Elem().Kind())
+		return typeError(head, offset, sliceTyp, "")
 	}
 
 	// handle array format family
@@ -314,26 +394,48 @@ func UnpackSlice(r io.Reader, ptr interface{}) error {
 		srcLen = int(head & 0x0f)
 	} else if head == 0xdc {
 		var temp uint16
-		if err = binary.Read(r, binary.BigEndian, &temp); err != nil {
+		if temp, err = d.readUint16(); err != nil {
 			return err
 		}
 		srcLen = int(temp)
 	} else if head == 0xdd {
 		var temp uint32
-		if err = binary.Read(r, binary.BigEndian, &temp); err != nil {
+		if temp, err = d.readUint32(); err != nil {
 			return err
 		}
 		srcLen = int(temp) // maybe overflow.
 	} else {
-		return fmt.Errorf("msgp: unpacked value is not an array")
+		return typeError(head, offset, sliceTyp, "")
 	}
 
-	sliceVal.Set(reflect.MakeSlice(reflect.SliceOf(sliceTyp.Elem()), srcLen, srcLen)) // slice 생성.
+	if err = d.enter(srcLen); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	// the slice grows as the elements are read, not to trust the length for allocation.
+	var slice reflect.Value
+	oldLen := 0
+	if d.merge && !sliceVal.IsNil() {
+		slice, oldLen = sliceVal.Slice(0, 0), sliceVal.Len()
+	} else {
+		slice = reflect.MakeSlice(sliceTyp, 0, preallocLen(srcLen)) // slice 생성.
+	}
+	zero := reflect.Zero(sliceTyp.Elem())
 	for inx := 0; inx < srcLen; inx++ {
-		if err = Unpack(r, sliceVal.Index(inx).Addr().Interface()); err != nil {
-			return err
+		if inx < slice.Cap() {
+			slice = slice.Slice(0, inx+1)
+			if inx >= oldLen {
+				slice.Index(inx).Set(zero)
+			}
+		} else {
+			slice = reflect.Append(slice, zero)
+		}
+		if err = Unpack(d, slice.Index(inx).Addr().Interface()); err != nil {
+			return wrapField(err, fmt.Sprintf("[%d]", inx))
 		}
 	}
+	sliceVal.Set(slice)
 	return nil
 }
 
@@ -342,10 +444,13 @@ func UnpackMap(r io.Reader, ptr interface{}) error {
 	var err error
 	var head byte
 
+	d := decoderOf(r)
+
 	mapTyp := reflect.TypeOf(ptr).Elem()
 	mapVal := reflect.ValueOf(ptr).Elem()
 
-	if err = binary.Read(r, binary.BigEndian, &head); err != nil {
+	offset := d.offset()
+	if head, err = d.readByte(); err != nil {
 		return err
 	}
 
@@ -359,30 +464,37 @@ func UnpackMap(r io.Reader, ptr interface{}) error {
 		srcLen = int(head & 0x0f)
 	} else if head == 0xde {
 		var temp uint16
-		if err = binary.Read(r, binary.BigEndian, &temp); err != nil {
+		if temp, err = d.readUint16(); err != nil {
 			return err
 		}
 		srcLen = int(temp)
 	} else if head == 0xdf {
 		var temp uint32
-		if err = binary.Read(r, binary.BigEndian, &temp); err != nil {
+		if temp, err = d.readUint32(); err != nil {
 			return err
 		}
 		srcLen = int(temp)
 	} else {
-		return fmt.Errorf("msgp: unpacked value is not a map")
+		return typeError(head, offset, mapTyp, "")
 	}
 
-	mapVal.Set(reflect.MakeMap(reflect.MapOf(mapTyp.Key(), mapTyp.Elem()))) // map 생성.
+	if err = d.enter(srcLen); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	if !d.merge || mapVal.IsNil() {
+		mapVal.Set(reflect.MakeMap(mapTyp)) // map 생성.
+	}
 	for inx := 0; inx < srcLen; inx++ {
 		keyPtr := reflect.New(mapTyp.Key())
-		if err = Unpack(r, keyPtr.Interface()); err != nil {
+		if err = Unpack(d, keyPtr.Interface()); err != nil {
 			return err
 		}
 
 		valPtr := reflect.New(mapTyp.Elem())
-		if err = Unpack(r, valPtr.Interface()); err != nil {
-			return err
+		if err = Unpack(d, valPtr.Interface()); err != nil {
+			return wrapField(err, fmt.Sprintf("[%v]", keyPtr.Elem()))
 		}
 		mapVal.SetMapIndex(keyPtr.Elem(), valPtr.Elem())
 	}
@@ -390,111 +502,233 @@ func UnpackMap(r io.Reader, ptr interface{}) error {
 }
 
 // UnpackStruct reads a struct value from the io.Reader. And assigns it to the value pointed by 'ptr'.
-// The struct value is deserialized from a map value.
+// The struct value is deserialized from a map value, or from an array value of the fields in field order.
 // If the fields of struct are not compatible with the value read, an error is returned.
+// The keys which don't match any field are skipped, unless the Decoder is in strict mode.
+// If the keys of the fields with the required option are absent, a *MissingFieldsError is returned.
 func UnpackStruct(r io.Reader, ptr interface{}) error {
 	var err error
 	var head byte
 
-	if err = binary.Read(r, binary.BigEndian, &head); err != nil {
+	d := decoderOf(r)
+
+	offset := d.offset()
+	if head, err = d.readByte(); err != nil {
 		return err
 	}
 	if head == 0xc0 { // nil
 		reflect.ValueOf(ptr).Elem().Set(reflect.Zero(reflect.TypeOf(ptr).Elem()))
 		return nil
 	}
+	unionKey := d.unionKey
+	d.unionKey = ""
 
 	var srcLen = 0
+	var asArray = false
 	if head&0xf0 == 0x80 { // map
 		srcLen = int(head & 0x0f)
 	} else if head == 0xde {
 		var temp uint16
-		if err = binary.Read(r, binary.BigEndian, &temp); err != nil {
+		if temp, err = d.readUint16(); err != nil {
 			return err
 		}
 		srcLen = int(temp)
 	} else if head == 0xdf {
 		var temp uint32
-		if err = binary.Read(r, binary.BigEndian, &temp); err != nil {
+		if temp, err = d.readUint32(); err != nil {
+			return err
+		}
+		srcLen = int(temp)
+	} else if head&0xf0 == 0x90 { // array
+		srcLen = int(head & 0x0f)
+		asArray = true
+	} else if head == 0xdc {
+		var temp uint16
+		if temp, err = d.readUint16(); err != nil {
+			return err
+		}
+		srcLen = int(temp)
+		asArray = true
+	} else if head == 0xdd {
+		var temp uint32
+		if temp, err = d.readUint32(); err != nil {
 			return err
 		}
 		srcLen = int(temp)
+		asArray = true
 	} else {
-		return fmt.Errorf("msgp: unpacked value is not a map")
+		return typeError(head, offset, reflect.TypeOf(ptr).Elem(), "")
 	}
 
-	type StructField struct {
-		Props FieldProps
-		Val   reflect.Value
+	if err = d.enter(srcLen); err != nil {
+		return err
 	}
-	fieldMap := make(map[string]StructField)
+	defer d.leave()
 
 	structTyp := reflect.TypeOf(ptr).Elem()
 	structVal := reflect.ValueOf(ptr).Elem()
 
-	structVal.Set(reflect.Zero(structTyp)) // init with zero value
-
-	structNumField := structTyp.NumField()
-	for inx := 0; inx < structNumField; inx++ {
-		var fp FieldProps
+	if !d.merge {
+		structVal.Set(reflect.Zero(structTyp)) // init with zero value
+	}
 
-		fieldTyp := structTyp.Field(inx)
-		fieldVal := structVal.Field(inx)
-		fp.parseTag(fieldTyp)
-		if fp.Skip {
-			continue
+	si := cachedStructInfo(structTyp, d.tagName, d.naming)
+	if asArray {
+		if err = unpackStructFromArray(d, structVal, si.fields, srcLen); err != nil {
+			return d.wrapStruct(err, structTyp)
 		}
-		fieldMap[fp.Name] = StructField{fp, fieldVal}
+		return checkStructFromArray(d, si, structVal, srcLen)
 	}
 
+	var unknown []string
+	var seen []bool
+	if si.hasRequired || si.hasDefault {
+		seen = make([]bool, len(si.fields))
+	}
 	for inx := 0; inx < srcLen; inx++ {
 		var key string
-		if err = Unpack(r, &key); err != nil {
+		var isStr bool
+		if key, isStr, err = d.ReadStructKey(); err != nil {
 			return err
 		}
+		if !isStr { // a key which can't match any field
+			if d.strict {
+				unknown = append(unknown, key)
+			}
+			if err = Skip(d); err != nil {
+				return d.wrapStruct(err, structTyp)
+			}
+			continue
+		}
 
-		structField, ok := fieldMap[key]
-		if ok {
-			if structField.Props.Skip {
-				continue
+		if sf, ok := si.fieldByKey(key, d.foldCase); ok {
+			err = wrapField(unpackFieldValue(d, structVal, sf), "."+sf.Name)
+			if seen != nil {
+				seen[sf.seq] = true
+			}
+		} else {
+			if d.strict && (unionKey == "" || key != unionKey) {
+				unknown = append(unknown, key)
 			}
+			err = Skip(d)
+		}
+		if err != nil {
+			return d.wrapStruct(err, structTyp)
+		}
+	}
 
-			if structField.Props.String {
-				var str string
-				if err = Unpack(r, &str); err != nil {
-					return err
-				}
-				if err = assignValueFromString(structField.Val, str); err != nil {
-					return err
-				}
-			} else {
-				if err = Unpack(r, structField.Val.Addr().Interface()); err != nil {
-					return err
-				}
+	if unknown != nil {
+		return &UnknownFieldsError{Type: structTyp, Keys: unknown}
+	}
+	if seen != nil {
+		if si.hasDefault && !d.merge {
+			if err = si.setDefaults(structVal, seen); err != nil {
+				return err
 			}
 		}
+		return si.missingFields(structTyp, seen)
 	}
+	return nil
+}
+
+// isStrOrBinHead reports whether 'head' is the head of a str or a bin value.
+func isStrOrBinHead(head byte) bool {
+	return head&0xe0 == 0xa0 || (head >= 0xd9 && head <= 0xdb) || (head >= 0xc4 && head <= 0xc6)
+}
 
+// checkStructFromArray checks the number of elements of an array read into a struct
+// for the strict mode and the required fields, and assigns the default values to the
+// fields whose elements are absent.
+func checkStructFromArray(d *Decoder, si *structInfo, structVal reflect.Value, srcLen int) error {
+	structTyp := structVal.Type()
+	if d.strict && srcLen > len(si.fields) {
+		unknown := make([]string, 0, srcLen-len(si.fields))
+		for inx := len(si.fields); inx < srcLen; inx++ {
+			unknown = append(unknown, strconv.Itoa(inx))
+		}
+		return &UnknownFieldsError{Type: structTyp, Keys: unknown}
+	}
+	if (si.hasRequired || si.hasDefault) && srcLen < len(si.fields) {
+		seen := make([]bool, len(si.fields))
+		for inx := 0; inx < srcLen; inx++ {
+			seen[inx] = true
+		}
+		if si.hasDefault && !d.merge {
+			if err := si.setDefaults(structVal, seen); err != nil {
+				return err
+			}
+		}
+		return si.missingFields(structTyp, seen)
+	}
 	return nil
 }
 
+// unpackStructFromArray reads the elements of an array into the fields of a struct in field order.
+// If the array is shorter than the fields, the remaining fields are left with zero values.
+// If the array is longer, the extra elements are read and discarded.
+func unpackStructFromArray(d *Decoder, structVal reflect.Value, fields []structField, srcLen int) error {
+	var err error
+
+	for inx := 0; inx < srcLen; inx++ {
+		if inx < len(fields) {
+			err = wrapField(unpackFieldValue(d, structVal, &fields[inx]), "."+fields[inx].Name)
+		} else {
+			err = Skip(d)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// wrapStruct prepends the name of the struct type to the field path of an UnpackTypeError,
+// if the struct is the outermost value being decoded.
+func (d *Decoder) wrapStruct(err error, typ reflect.Type) error {
+	if e, ok := err.(pathError); ok && d.depth == 1 {
+		path := e.fieldPath()
+		if typ.Name() != "" {
+			*path = typ.Name() + *path
+		} else {
+			*path = strings.TrimPrefix(*path, ".")
+		}
+	}
+	return err
+}
+
+// unpackFieldValue reads a value into the field of a struct according to the field properties.
+func unpackFieldValue(d *Decoder, structVal reflect.Value, sf *structField) error {
+	fieldVal, err := fieldByIndexAlloc(structVal, sf.Index)
+	if err != nil {
+		return err
+	}
+	return sf.unpack(d, fieldVal)
+}
+
 // UnpackPtr reads a value from the io.Reader. And assigns it to the value pointed by 'ptr'.
 // 'ptr' should be a pointer of pointer.
 func UnpackPtr(r io.Reader, ptr interface{}) error {
 	var err error
 	var peek byte
 
-	br := NewPeekableReader(r)
-	if peek, err = br.Peek(); err != nil {
+	d := decoderOf(r)
+
+	if peek, err = d.peekByte(); err != nil {
 		return err
 	}
 	if peek == 0xc0 { // nil value unpacked.
+		d.r++
 		reflect.ValueOf(ptr).Elem().Set(reflect.Zero(reflect.TypeOf(ptr).Elem()))
 		return nil
 	}
 
+	if existing := reflect.ValueOf(ptr).Elem(); d.merge && !existing.IsNil() {
+		return Unpack(d, existing.Interface())
+	}
+
 	newVal := reflect.New(reflect.TypeOf(ptr).Elem().Elem())
-	if err = Unpack(br, newVal.Interface()); err != nil { // peeked byte will be consumed in Unpack()
+	if err = Unpack(d, newVal.Interface()); err != nil { // peeked byte will be consumed in Unpack()
 		return err
 	}
 
@@ -504,18 +738,37 @@ func UnpackPtr(r io.Reader, ptr interface{}) error {
 
 // UnpackInterface reads a value from the io.Reader. And assigns it to the value pointed by 'ptr'.
 // If you don't know the type, you can use this function. but you will have to use reflection to discover the type of the value read.
+// For an interface type with methods, the value must be a map of a union registered by
+// RegisterUnion, or be packed with the name of a type registered by RegisterType.
 func UnpackInterface(r io.Reader, ptr interface{}) error {
 	var err error
+	var val interface{}
+
+	if pi, ok := ptr.(*interface{}); ok {
+		*pi, err = UnpackPrimitive(r)
+		return err
+	}
 
-	pi, ok := ptr.(*interface{})
-	if !ok {
-		return fmt.Errorf("msgp: specified type[%v] is not supported", reflect.TypeOf(ptr).Elem())
+	wantType := reflect.TypeOf(ptr).Elem()
+	if wantType.Kind() != reflect.Interface {
+		return &UnsupportedTypeError{Type: wantType}
+	}
+	if wantType.NumMethod() != 0 {
+		if u := unionInfoOf(wantType); u != nil {
+			return unpackUnion(decoderOf(r), u, ptr)
+		}
+		return unpackRegisteredType(decoderOf(r), ptr)
 	}
 
-	if *pi, err = UnpackPrimitive(r); err != nil {
+	if val, err = UnpackPrimitive(r); err != nil {
 		return err
 	}
 
+	if val == nil {
+		reflect.ValueOf(ptr).Elem().Set(reflect.Zero(wantType))
+	} else {
+		reflect.ValueOf(ptr).Elem().Set(reflect.ValueOf(val))
+	}
 	return nil
 }
 
@@ -526,7 +779,9 @@ func UnpackPrimitive(r io.Reader) (interface{}, error) {
 	var err error
 	var head byte
 
-	if err = binary.Read(r, binary.BigEndian, &head); err != nil {
+	d := decoderOf(r)
+
+	if head, err = d.readByte(); err != nil {
 		return nil, err
 	}
 
@@ -541,253 +796,237 @@ func UnpackPrimitive(r io.Reader) (interface{}, error) {
 	} else if head&0xe0 == 0xe0 {
 		return int8(head), nil
 	} else if head == 0xd0 {
-		return unpackInt8(r)
+		return unpackInt8(d)
 	} else if head == 0xd1 {
-		return unpackInt16(r)
+		return unpackInt16(d)
 	} else if head == 0xd2 {
-		return unpackInt32(r)
+		return unpackInt32(d)
 	} else if head == 0xd3 {
-		return unpackInt64(r)
+		return unpackInt64(d)
 	} else if head == 0xcc {
-		return unpackUint8(r)
+		return unpackUint8(d)
 	} else if head == 0xcd {
-		return unpackUint16(r)
+		return unpackUint16(d)
 	} else if head == 0xce {
-		return unpackUint32(r)
+		return unpackUint32(d)
 	} else if head == 0xcf {
-		return unpackUint64(r)
+		return unpackUint64(d)
 	} else if head == 0xca {
-		return unpackFloat32(r)
+		return unpackFloat32(d)
 	} else if head == 0xcb {
-		return unpackFloat64(r)
+		return unpackFloat64(d)
 	} else if head&0xe0 == 0xa0 {
-		return unpackString5(r, int(head&0x1f))
+		return unpackString5(d, int(head&0x1f))
 	} else if head == 0xd9 {
-		return unpackString8(r)
+		return unpackString8(d)
 	} else if head == 0xda {
-		return unpackString16(r)
+		return unpackString16(d)
 	} else if head == 0xdb {
-		return unpackString32(r)
+		return unpackString32(d)
 	} else if head == 0xc4 { // bin
-		return unpackBin8(r)
+		return unpackBin8(d)
 	} else if head == 0xc5 {
-		return unpackBin16(r)
+		return unpackBin16(d)
 	} else if head == 0xc6 {
-		return unpackBin32(r)
+		return unpackBin32(d)
 	} else if head&0xf0 == 0x90 { // array
-		return unpackArray4(r, int(head&0x0f))
+		return unpackArray4(d, int(head&0x0f))
 	} else if head == 0xdc {
-		return unpackArray16(r)
+		return unpackArray16(d)
 	} else if head == 0xdd {
-		return unpackArray32(r)
+		return unpackArray32(d)
 	} else if head&0xf0 == 0x80 { // map
-		return unpackMap4(r, int(head&0x0f))
+		return unpackMap4(d, int(head&0x0f))
 	} else if head == 0xde {
-		return unpackMap16(r)
+		return unpackMap16(d)
 	} else if head == 0xdf {
-		return unpackMap32(r)
+		return unpackMap32(d)
+	} else if isExtHead(head) { // ext
+		return unpackExt(d, head)
 	}
 
 	return nil, errors.New("msgp: UnpackPrimitive() reads unsupported(array, map) format family")
 }
 
-func unpackInt8(r io.Reader) (int8, error) {
-	var val int8
-	err := binary.Read(r, binary.BigEndian, &val)
-	return val, err
+func unpackInt8(d *Decoder) (int8, error) {
+	b, err := d.readByte()
+	return int8(b), err
 }
 
-func unpackInt16(r io.Reader) (int16, error) {
-	var val int16
-	err := binary.Read(r, binary.BigEndian, &val)
-	return val, err
+func unpackInt16(d *Decoder) (int16, error) {
+	val, err := d.readUint16()
+	return int16(val), err
 }
 
-func unpackInt32(r io.Reader) (int32, error) {
-	var val int32
-	err := binary.Read(r, binary.BigEndian, &val)
-	return val, err
+func unpackInt32(d *Decoder) (int32, error) {
+	val, err := d.readUint32()
+	return int32(val), err
 }
 
-func unpackInt64(r io.Reader) (int64, error) {
-	var val int64
-	err := binary.Read(r, binary.BigEndian, &val)
-	return val, err
+func unpackInt64(d *Decoder) (int64, error) {
+	val, err := d.readUint64()
+	return int64(val), err
 }
 
-func unpackUint8(r io.Reader) (uint8, error) {
-	var val uint8
-	err := binary.Read(r, binary.BigEndian, &val)
-	return val, err
+func unpackUint8(d *Decoder) (uint8, error) {
+	return d.readByte()
 }
 
-func unpackUint16(r io.Reader) (uint16, error) {
-	var val uint16
-	err := binary.Read(r, binary.BigEndian, &val)
-	return val, err
+func unpackUint16(d *Decoder) (uint16, error) {
+	return d.readUint16()
 }
 
-func unpackUint32(r io.Reader) (uint32, error) {
-	var val uint32
-	err := binary.Read(r, binary.BigEndian, &val)
-	return val, err
+func unpackUint32(d *Decoder) (uint32, error) {
+	return d.readUint32()
 }
 
-func unpackUint64(r io.Reader) (uint64, error) {
-	var val uint64
-	err := binary.Read(r, binary.BigEndian, &val)
-	return val, err
+func unpackUint64(d *Decoder) (uint64, error) {
+	return d.readUint64()
 }
 
-func unpackFloat32(r io.Reader) (float32, error) {
-	buf := make([]byte, 4)
-	if _, err := r.Read(buf); err != nil {
+func unpackFloat32(d *Decoder) (float32, error) {
+	bits, err := d.readUint32()
+	if err != nil {
 		return 0, err
 	}
-
-	bits := binary.BigEndian.Uint32(buf)
 	return math.Float32frombits(bits), nil
 }
 
-func unpackFloat64(r io.Reader) (float64, error) {
-	buf := make([]byte, 8)
-	if _, err := r.Read(buf); err != nil {
+func unpackFloat64(d *Decoder) (float64, error) {
+	bits, err := d.readUint64()
+	if err != nil {
 		return 0, err
 	}
-
-	bits := binary.BigEndian.Uint64(buf)
 	return math.Float64frombits(bits), nil
 }
 
-func unpackString5(r io.Reader, len int) (string, error) {
-	return unpackStringBody(r, len)
+func unpackString5(d *Decoder, len int) (string, error) {
+	return unpackStringBody(d, len)
 }
 
-func unpackString8(r io.Reader) (string, error) {
-	var len uint8
-	if err := binary.Read(r, binary.BigEndian, &len); err != nil {
+func unpackString8(d *Decoder) (string, error) {
+	len, err := d.readByte()
+	if err != nil {
 		return "", err
 	}
-	return unpackStringBody(r, int(len))
+	return unpackStringBody(d, int(len))
 }
 
-func unpackString16(r io.Reader) (string, error) {
-	var len uint16
-	if err := binary.Read(r, binary.BigEndian, &len); err != nil {
+func unpackString16(d *Decoder) (string, error) {
+	len, err := d.readUint16()
+	if err != nil {
 		return "", err
 	}
-	return unpackStringBody(r, int(len))
+	return unpackStringBody(d, int(len))
 }
 
-func unpackString32(r io.Reader) (string, error) {
-	var len uint32
-	if err := binary.Read(r, binary.BigEndian, &len); err != nil {
+func unpackString32(d *Decoder) (string, error) {
+	len, err := d.readUint32()
+	if err != nil {
 		return "", err
 	}
-	return unpackStringBody(r, int(len))
+	return unpackStringBody(d, int(len))
 }
 
-func unpackBin8(r io.Reader) ([]byte, error) {
-	var len uint8
-	if err := binary.Read(r, binary.BigEndian, &len); err != nil {
+func unpackBin8(d *Decoder) ([]byte, error) {
+	len, err := d.readByte()
+	if err != nil {
 		return nil, err
 	}
-	return unpackBinBody(r, int(len))
+	return unpackBinBody(d, int(len))
 }
 
-func unpackBin16(r io.Reader) ([]byte, error) {
-	var len uint16
-	if err := binary.Read(r, binary.BigEndian, &len); err != nil {
+func unpackBin16(d *Decoder) ([]byte, error) {
+	len, err := d.readUint16()
+	if err != nil {
 		return nil, err
 	}
-	return unpackBinBody(r, int(len))
+	return unpackBinBody(d, int(len))
 }
 
-func unpackBin32(r io.Reader) ([]byte, error) {
-	var len uint32
-	if err := binary.Read(r, binary.BigEndian, &len); err != nil {
+func unpackBin32(d *Decoder) ([]byte, error) {
+	len, err := d.readUint32()
+	if err != nil {
 		return nil, err
 	}
-	return unpackBinBody(r, int(len))
+	return unpackBinBody(d, int(len))
 }
 
-func unpackArray4(r io.Reader, len int) (interface{}, error) {
-	return unpackArrayBody(r, len)
+func unpackArray4(d *Decoder, len int) (interface{}, error) {
+	return unpackArrayBody(d, len)
 }
 
-func unpackArray16(r io.Reader) (interface{}, error) {
-	var len uint16
-	if err := binary.Read(r, binary.BigEndian, &len); err != nil {
+func unpackArray16(d *Decoder) (interface{}, error) {
+	len, err := d.readUint16()
+	if err != nil {
 		return nil, err
 	}
-	return unpackArrayBody(r, int(len))
+	return unpackArrayBody(d, int(len))
 }
 
-func unpackArray32(r io.Reader) (interface{}, error) {
-	var len uint32
-	if err := binary.Read(r, binary.BigEndian, &len); err != nil {
+func unpackArray32(d *Decoder) (interface{}, error) {
+	len, err := d.readUint32()
+	if err != nil {
 		return nil, err
 	}
-	return unpackArrayBody(r, int(len))
+	return unpackArrayBody(d, int(len))
 }
 
-func unpackMap4(r io.Reader, len int) (interface{}, error) {
-	return unpackMapBody(r, len)
+func unpackMap4(d *Decoder, len int) (interface{}, error) {
+	return unpackMapBody(d, len)
 }
 
-func unpackMap16(r io.Reader) (interface{}, error) {
-	var len uint16
-	if err := binary.Read(r, binary.BigEndian, &len); err != nil {
+func unpackMap16(d *Decoder) (interface{}, error) {
+	len, err := d.readUint16()
+	if err != nil {
 		return nil, err
 	}
-	return unpackMapBody(r, int(len))
+	return unpackMapBody(d, int(len))
 }
 
-func unpackMap32(r io.Reader) (interface{}, error) {
-	var len uint32
-	if err := binary.Read(r, binary.BigEndian, &len); err != nil {
+func unpackMap32(d *Decoder) (interface{}, error) {
+	len, err := d.readUint32()
+	if err != nil {
 		return nil, err
 	}
-	return unpackMapBody(r, int(len))
+	return unpackMapBody(d, int(len))
 }
 
-func unpackStringBody(r io.Reader, len int) (string, error) {
+func unpackStringBody(d *Decoder, len int) (string, error) {
 	if len == 0 {
 		return "", nil
 	}
-
-	var n int
-	var err error
-	str := make([]byte, len)
-	if n, err = r.Read(str); err != nil {
+	if err := d.checkStringLen(len); err != nil {
 		return "", err
 	}
-	if n != len {
-		return "", errors.New("msgp: broken string format family was found")
+
+	str, err := d.next(len)
+	if err != nil {
+		return "", err
 	}
 
 	return string(str), nil
 }
 
-func unpackBinBody(r io.Reader, len int) ([]byte, error) {
+func unpackBinBody(d *Decoder, len int) ([]byte, error) {
 	if len == 0 {
 		return nil, nil // nil as an empty slice
 	}
-
-	var n int
-	var err error
-	bin := make([]byte, len)
-	if n, err = r.Read(bin); err != nil {
+	if err := d.checkStringLen(len); err != nil {
 		return nil, err
 	}
-	if n != len {
-		return nil, errors.New("msgp: broken binary format family was found")
+
+	p, err := d.next(len)
+	if err != nil {
+		return nil, err
 	}
 
+	bin := make([]byte, len)
+	copy(bin, p)
 	return bin, nil
 }
 
-func unpackArrayBody(r io.Reader, len int) (interface{}, error) {
+func unpackArrayBody(d *Decoder, len int) (interface{}, error) {
 	if len == 0 {
 		return nil, nil // nil as an empty slice
 	}
@@ -795,17 +1034,22 @@ func unpackArrayBody(r io.Reader, len int) (interface{}, error) {
 	var err error
 	var val interface{}
 
-	slice := make([]interface{}, len, len)
+	if err = d.enter(len); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	slice := make([]interface{}, 0, preallocLen(len))
 	for inx := 0; inx < len; inx++ {
-		if val, err = UnpackPrimitive(r); err != nil {
+		if val, err = UnpackPrimitive(d); err != nil {
 			return nil, err
 		}
-		slice[inx] = val
+		slice = append(slice, val)
 	}
 	return slice, nil
 }
 
-func unpackMapBody(r io.Reader, len int) (interface{}, error) {
+func unpackMapBody(d *Decoder, len int) (interface{}, error) {
 	if len == 0 {
 		return nil, nil // nil as an empty map
 	}
@@ -813,12 +1057,17 @@ func unpackMapBody(r io.Reader, len int) (interface{}, error) {
 	var err error
 	var key, val interface{}
 
+	if err = d.enter(len); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
 	mapVal := make(map[interface{}]interface{})
 	for inx := 0; inx < len; inx++ {
-		if key, err = UnpackPrimitive(r); err != nil {
+		if key, err = UnpackPrimitive(d); err != nil {
 			return nil, err
 		}
-		if val, err = UnpackPrimitive(r); err != nil {
+		if val, err = UnpackPrimitive(d); err != nil {
 			return nil, err
 		}
 		mapVal[key] = val
@@ -826,6 +1075,148 @@ func unpackMapBody(r io.Reader, len int) (interface{}, error) {
 	return mapVal, nil
 }
 
+// Skip reads a value of any format family from the io.Reader and discards it.
+// The elements of arrays and maps are skipped as well. The value is not decoded,
+// and no memory is allocated for it.
+func Skip(r io.Reader) error {
+	d := decoderOf(r)
+
+	for remain, first := 1, true; remain > 0; remain, first = remain-1, false {
+		if err := d.fill(1); err != nil {
+			if err == io.EOF && !first {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if err := d.fill(headerSize(d.buf[d.r])); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if err := d.checkHeaderLimits(); err != nil {
+			return err
+		}
+
+		size, err := valueSize(d.buf[d.r:d.w])
+		if err != nil {
+			return err
+		}
+
+		switch head := d.buf[d.r]; {
+		case head&0xf0 == 0x90 || head == 0xdc || head == 0xdd: // array
+			n, _, _, _ := readHeaderBytes(d.buf[d.r:d.w])
+			remain += n
+			d.r += size
+		case head&0xf0 == 0x80 || head == 0xde || head == 0xdf: // map
+			n, _, _, _ := readHeaderBytes(d.buf[d.r:d.w])
+			remain += n * 2
+			d.r += size
+		default:
+			if err = d.discard(size); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// appendRawValue reads a value of any format family and appends its encoded bytes to 'dst'.
+func appendRawValue(d *Decoder, dst []byte) ([]byte, error) {
+	var err error
+	var head byte
+	var p []byte
+
+	if err = d.enter(0); err != nil { // counts the bytes of the value for MaxBytes.
+		return dst, err
+	}
+	defer d.leave()
+
+	for remain := 1; remain > 0; remain-- {
+		if head, err = d.readByte(); err != nil {
+			if err == io.EOF && len(dst) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return dst, err
+		}
+		dst = append(dst, head)
+
+		size := 0 // size of the following data
+		switch {
+		case head <= 0x7f || head >= 0xe0 || head == 0xc0 || head == 0xc2 || head == 0xc3:
+		case head&0xe0 == 0xa0: // fixstr
+			size = int(head & 0x1f)
+		case head&0xf0 == 0x90: // fixarray
+			remain += int(head & 0x0f)
+		case head&0xf0 == 0x80: // fixmap
+			remain += int(head&0x0f) * 2
+		case head == 0xcc || head == 0xd0:
+			size = 1
+		case head == 0xcd || head == 0xd1:
+			size = 2
+		case head == 0xce || head == 0xd2 || head == 0xca:
+			size = 4
+		case head == 0xcf || head == 0xd3 || head == 0xcb:
+			size = 8
+		case head >= 0xd4 && head <= 0xd8: // fixext
+			size = 1 + 1<<(head-0xd4)
+		case head == 0xd9 || head == 0xc4 || head == 0xc7 ||
+			head == 0xda || head == 0xc5 || head == 0xc8 || head == 0xdc || head == 0xde ||
+			head == 0xdb || head == 0xc6 || head == 0xc9 || head == 0xdd || head == 0xdf:
+			var len int
+			switch head {
+			case 0xd9, 0xc4, 0xc7:
+				if p, err = d.next(1); err == nil {
+					len = int(p[0])
+				}
+			case 0xda, 0xc5, 0xc8, 0xdc, 0xde:
+				if p, err = d.next(2); err == nil {
+					len = int(binary.BigEndian.Uint16(p))
+				}
+			default:
+				if p, err = d.next(4); err == nil {
+					len = int(binary.BigEndian.Uint32(p))
+				}
+			}
+			if err != nil {
+				return dst, err
+			}
+			dst = append(dst, p...)
+
+			if head >= 0xdc && head <= 0xdf {
+				err = d.checkContainerLen(len)
+			} else {
+				err = d.checkStringLen(len)
+			}
+			if err != nil {
+				return dst, err
+			}
+
+			switch head {
+			case 0xdc, 0xdd: // array
+				remain += len
+			case 0xde, 0xdf: // map
+				remain += len * 2
+			case 0xc7, 0xc8, 0xc9: // ext
+				size = 1 + len
+			default:
+				size = len
+			}
+		default:
+			return dst, fmt.Errorf("msgp: unknown format[0x%02x] was found", head)
+		}
+
+		if size > 0 {
+			if p, err = d.next(size); err != nil {
+				return dst, err
+			}
+			dst = append(dst, p...)
+		}
+	}
+
+	return dst, nil
+}
+
 func assignValueFromString(dest reflect.Value, str string) error {
 	var err error
 
@@ -860,7 +1251,7 @@ func assignValueFromString(dest reflect.Value, str string) error {
 		case reflect.String:
 			dest.SetString(str)
 		case reflect.Ptr:
-			dest.Set(reflect.New(dest.Elem().Type()))
+			dest.Set(reflect.New(dest.Type().Elem()))
 			return assignValueFromString(dest.Elem(), str)
 		}
 	}
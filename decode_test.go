@@ -2,7 +2,12 @@ package msgp
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
 )
 
 func ExampleUnpack() {
@@ -656,3 +661,408 @@ func ExampleUnpack_struct() {
 	// {1234567890 255 12345 0 34 51 100 0}
 	// {1234567890 255 12345 0 34 51 100 0}
 }
+
+func ExampleUnpack_definedTypes() {
+	type myStruct struct {
+		ID     userID
+		Status status
+		Level  level   `msgp:",string"`
+		Parent *userID `msgp:",string"`
+	}
+
+	var err error
+	var buf bytes.Buffer
+	var id userID
+	var st status
+	var fl flags
+	var la [2]level
+	var m map[status]userID
+	var s myStruct
+
+	parent := userID(7)
+	Pack(&buf, userID(0x7fff))
+	Pack(&buf, status("ok"))
+	Pack(&buf, flags{1, 2})
+	Pack(&buf, [2]level{3, 4})
+	Pack(&buf, map[status]userID{"a": 1})
+	Pack(&buf, myStruct{1, "ok", 2, &parent})
+
+	for _, ptr := range []interface{}{&id, &st, &fl, &la, &m, &s} {
+		if err = Unpack(&buf, ptr); err != nil {
+			fmt.Println(err)
+		}
+	}
+	fmt.Printf("%v %v %v %v %v\n", int64(id), st, fl, []uint8{uint8(la[0]), uint8(la[1])}, m)
+	fmt.Printf("%v %v %v %v\n", int64(s.ID), s.Status, uint8(s.Level), int64(*s.Parent))
+
+	// Output:
+	// 32767 ok [1 2] [3 4] map[a:1]
+	// 1 ok 2 7
+}
+
+func ExampleUnpack_structFromArray() {
+	var p point3
+
+	Unpack(bytes.NewBuffer([]byte{0x93, 0x01, 0x02, 0x03}), &p)
+	fmt.Println(p.X, p.Y, p.Z)
+
+	// shorter array: the remaining fields are zero.
+	Unpack(bytes.NewBuffer([]byte{0x91, 0x04}), &p)
+	fmt.Println(p.X, p.Y, p.Z)
+
+	// longer array: the extra elements are discarded.
+	buf := bytes.NewBuffer([]byte{0x95, 0x05, 0x06, 0x07, 0x92, 0x01, 0x02, 0xa1, 0x61, 0xc3})
+	Unpack(buf, &p)
+	fmt.Println(p.X, p.Y, p.Z)
+
+	var b bool
+	Unpack(buf, &b)
+	fmt.Println(b)
+
+	// Output:
+	// 1 2 3
+	// 4 0 0
+	// 5 6 7
+	// true
+}
+
+func ExampleSkip() {
+	var buf bytes.Buffer
+
+	Pack(&buf, map[string]interface{}{"a": []interface{}{1, "x", []byte{1, 2}}})
+	Pack(&buf, "next")
+
+	Skip(&buf)
+
+	var s string
+	Unpack(&buf, &s)
+	fmt.Println(s)
+
+	// Output:
+	// next
+}
+
+func TestSkip(t *testing.T) {
+	values := []interface{}{nil, false, 1, -100000, uint64(1 << 40), float32(1.5), 2.5,
+		"s", strings.Repeat("a", 0x100), strings.Repeat("b", 0x10000), []byte("bin"), make([]byte, 0x10000),
+		Extension{1, []byte{1}}, Extension{2, make([]byte, 3)}, Extension{3, make([]byte, 0x100)},
+		[]interface{}{1, []interface{}{2, map[string]int{"x": 3}}}, make([]int, 0x10),
+		map[string]interface{}{"a": map[string]interface{}{"b": []string{"c"}}}}
+
+	for _, v := range values {
+		var buf bytes.Buffer
+		if err := Pack(&buf, v); err != nil {
+			t.Fatal(err)
+		}
+		size := buf.Len()
+		Pack(&buf, true)
+
+		// plain reader, buffering decoder and one byte reader
+		for _, r := range []io.Reader{
+			bytes.NewReader(buf.Bytes()),
+			NewDecoder(bytes.NewReader(buf.Bytes())),
+			NewDecoder(oneByteReader{bytes.NewReader(buf.Bytes())}),
+		} {
+			if err := Skip(r); err != nil {
+				t.Fatalf("%T(%d bytes): %v", v, size, err)
+			}
+			var b bool
+			if err := Unpack(r, &b); err != nil || !b {
+				t.Fatalf("%T(%d bytes): stream is out of sync: %v", v, size, err)
+			}
+		}
+	}
+}
+
+func TestSkipErrors(t *testing.T) {
+	if err := Skip(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+	for _, b := range [][]byte{{0x92, 0x01}, {0xa3, 'a'}, {0xc5, 0x01}, {0x81, 0xa1, 'a'}} {
+		if err := Skip(bytes.NewReader(b)); err != io.ErrUnexpectedEOF {
+			t.Errorf("% x: err = %v, want io.ErrUnexpectedEOF", b, err)
+		}
+	}
+	if err := Skip(bytes.NewReader([]byte{0xc1})); err == nil {
+		t.Error("unknown format is skipped")
+	}
+}
+
+func TestSkipDoesNotAllocate(t *testing.T) {
+	var one bytes.Buffer
+	Pack(&one, map[string]interface{}{"a": []interface{}{1, "x", []byte{1, 2}, 1.5}, "b": strings.Repeat("c", 100)})
+	input := bytes.Repeat(one.Bytes(), 200)
+
+	dec := NewDecoder(bytes.NewReader(input))
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := dec.Skip(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("allocs = %v, want 0", allocs)
+	}
+}
+
+func TestUnpackStructSkipsUnknownKeys(t *testing.T) {
+	type known struct {
+		A int
+		C string
+	}
+
+	var buf bytes.Buffer
+	Pack(&buf, map[string]interface{}{"A": 1, "B": []interface{}{map[string]int{"x": 1}, "y"}, "C": "c", "D": []byte{1}})
+	Pack(&buf, "next")
+
+	var k known
+	if err := Unpack(&buf, &k); err != nil {
+		t.Fatal(err)
+	}
+	if k.A != 1 || k.C != "c" {
+		t.Errorf("unexpected value: %+v", k)
+	}
+
+	var s string
+	if err := Unpack(&buf, &s); err != nil || s != "next" {
+		t.Errorf("stream is out of sync: %q, %v", s, err)
+	}
+}
+
+func ExampleDecoder_UseStrictMode() {
+	type config struct {
+		Host string `msgp:"host,required"`
+		Port int    `msgp:"port"`
+	}
+
+	var buf bytes.Buffer
+	Pack(&buf, map[string]interface{}{"host": "localhost", "prot": 8080})
+	Pack(&buf, map[string]interface{}{"port": 8080})
+
+	d := NewDecoder(&buf)
+	d.UseStrictMode(true)
+
+	var c config
+	fmt.Println(d.Decode(&c))
+	fmt.Println(d.Decode(&c))
+
+	// Output:
+	// msgp: unknown keys "prot" for Go struct msgp.config
+	// msgp: missing required keys "host" for Go struct msgp.config
+}
+
+type strictInner struct {
+	A int `msgp:"a,required"`
+	B int `msgp:"b,required"`
+}
+
+type strictOuter struct {
+	Inner []strictInner
+	Name  string
+}
+
+func TestUnpackStructStrict(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		strict  bool
+		unknown []string
+		missing []string
+		field   string
+	}{
+		{map[string]interface{}{"a": 1, "b": 2, "c": 3}, false, nil, nil, ""},
+		{map[string]interface{}{"a": 1, "b": 2, "c": 3}, true, []string{"c"}, nil, ""},
+		{map[interface{}]interface{}{"a": 1, "b": 2, 1: []int{2}}, false, nil, nil, ""},
+		{map[interface{}]interface{}{"a": 1, "b": 2, 1: []int{2}}, true, []string{"1"}, nil, ""},
+		{map[interface{}]interface{}{"a": 1, nil: 2}, false, nil, []string{"b"}, ""},
+		{map[string]interface{}{"b": 2}, false, nil, []string{"a"}, ""},
+		{map[string]interface{}{}, false, nil, []string{"a", "b"}, ""},
+		{nil, true, nil, nil, ""},
+		{[]int{1, 2}, true, nil, nil, ""},
+		{[]int{1}, false, nil, []string{"b"}, ""},
+		{[]int{1, 2, 3, 4}, false, nil, nil, ""},
+		{[]int{1, 2, 3, 4}, true, []string{"2", "3"}, nil, ""},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		Pack(&buf, test.value)
+		Pack(&buf, "next")
+
+		d := NewDecoder(&buf)
+		d.UseStrictMode(test.strict)
+		var v strictInner
+		err := d.Decode(&v)
+
+		var ue *UnknownFieldsError
+		var me *MissingFieldsError
+		switch {
+		case test.unknown != nil:
+			if !errors.As(err, &ue) || !reflect.DeepEqual(ue.Keys, test.unknown) || ue.Type != reflect.TypeOf(v) {
+				t.Errorf("%v: got error %v, want unknown keys %v", test.value, err, test.unknown)
+			}
+		case test.missing != nil:
+			if !errors.As(err, &me) || !reflect.DeepEqual(me.Keys, test.missing) {
+				t.Errorf("%v: got error %v, want missing keys %v", test.value, err, test.missing)
+			}
+		case err != nil:
+			t.Errorf("%v: unexpected error %v", test.value, err)
+		}
+
+		var s string
+		if err := d.Decode(&s); err != nil || s != "next" {
+			t.Errorf("%v: stream is out of sync: %q, %v", test.value, s, err)
+		}
+	}
+}
+
+func TestUnpackStructStrictPath(t *testing.T) {
+	var buf bytes.Buffer
+	Pack(&buf, map[string]interface{}{
+		"Inner": []interface{}{map[string]int{"a": 1, "b": 2}, map[string]int{"a": 1, "x": 2}},
+	})
+
+	var v strictOuter
+	err := Unpack(&buf, &v)
+
+	var me *MissingFieldsError
+	if !errors.As(err, &me) || me.Field != "strictOuter.Inner[1]" {
+		t.Errorf("got error %v", err)
+	}
+	want := `msgp: missing required keys "b" for Go struct strictOuter.Inner[1] of type msgp.strictInner`
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
+
+func ExampleDecoder_UseMergeMode() {
+	type config struct {
+		Host string
+		Port int
+		Tags map[string]string
+	}
+
+	var buf bytes.Buffer
+	Pack(&buf, map[string]interface{}{"Port": 8080, "Tags": map[string]string{"env": "prod"}})
+
+	c := config{Host: "localhost", Port: 80, Tags: map[string]string{"app": "web"}}
+	d := NewDecoder(&buf)
+	d.UseMergeMode(true)
+	d.Decode(&c)
+	fmt.Println(c.Host, c.Port, c.Tags)
+
+	// Output:
+	// localhost 8080 map[app:web env:prod]
+}
+
+func TestMergeMode(t *testing.T) {
+	type inner struct {
+		A, B int
+	}
+	type outer struct {
+		Name   string
+		In     inner
+		Ptr    *inner
+		Slice  []inner
+		Bytes  []byte
+		Arr    [3]int
+		Map    map[string]inner
+		Absent int
+	}
+
+	// the input has no Name, Absent and B.
+	var buf bytes.Buffer
+	err := Pack(&buf, map[string]interface{}{
+		"In":    map[string]int{"A": 1},
+		"Ptr":   map[string]int{"A": 1},
+		"Slice": []map[string]int{{"A": 1}},
+		"Bytes": []byte("ab"),
+		"Arr":   []int{1},
+		"Map":   map[string]inner{"new": {A: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	backing := make([]inner, 2, 4)
+	backing[0], backing[1] = inner{A: 9, B: 2}, inner{A: 9, B: 3}
+	bytesBacking := make([]byte, 0, 8)
+	ptr := &inner{B: 2}
+	dst := outer{
+		Name:   "kept",
+		In:     inner{B: 2},
+		Ptr:    ptr,
+		Slice:  backing,
+		Bytes:  bytesBacking,
+		Arr:    [3]int{7, 8, 9},
+		Map:    map[string]inner{"old": {B: 2}},
+		Absent: 5,
+	}
+
+	d := NewDecoder(bytes.NewReader(data))
+	d.UseMergeMode(true)
+	if err := d.Decode(&dst); err != nil {
+		t.Fatal(err)
+	}
+
+	want := outer{
+		Name:   "kept",
+		In:     inner{A: 1, B: 2},
+		Ptr:    &inner{A: 1, B: 2},
+		Slice:  []inner{{A: 1, B: 2}},
+		Bytes:  []byte("ab"),
+		Arr:    [3]int{1, 0, 0},
+		Map:    map[string]inner{"old": {B: 2}, "new": {A: 1}},
+		Absent: 5,
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("got %+v, want %+v", dst, want)
+	}
+	if dst.Ptr != ptr {
+		t.Error("pointer is not reused")
+	}
+	if &dst.Slice[0] != &backing[0] {
+		t.Error("backing array of slice is not reused")
+	}
+	if &dst.Bytes[0] != &bytesBacking[:1][0] {
+		t.Error("backing array of []byte is not reused")
+	}
+
+	// without merge mode, everything is replaced.
+	dst = outer{Name: "replaced", Map: map[string]inner{"old": {}}}
+	if err := Unpack(bytes.NewReader(data), &dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Name != "" || len(dst.Map) != 1 {
+		t.Errorf("got %+v", dst)
+	}
+}
+
+func TestMergeModeSliceGrowth(t *testing.T) {
+	data := AppendArrayHeader(nil, 4)
+	for inx := 1; inx <= 4; inx++ {
+		data = AppendMapHeader(data, 1)
+		data = AppendInt(AppendString(data, "A"), int64(inx))
+	}
+
+	type elem struct{ A, B int }
+	backing := make([]elem, 3)
+	backing[0] = elem{B: 10}
+	s := backing[:1]
+	backing[1] = elem{B: 20} // beyond the length: zeroed before read.
+
+	d := NewDecoder(bytes.NewReader(data))
+	d.UseMergeMode(true)
+	if err := d.Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	want := []elem{{1, 10}, {2, 0}, {3, 0}, {4, 0}}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("got %v, want %v", s, want)
+	}
+
+	// a nil value still sets the zero value.
+	d = NewDecoder(bytes.NewReader([]byte{0xc0}))
+	d.UseMergeMode(true)
+	if err := d.Decode(&s); err != nil || s != nil {
+		t.Errorf("got %v, err %v", s, err)
+	}
+}
@@ -0,0 +1,499 @@
+package msgp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	defaultTagName = "msgp"
+	defaultBufSize = 4096
+	minBufSize     = 16
+)
+
+// Decoder reads and decodes msgpack values from an input stream.
+// A Decoder keeps its options and its buffered input between calls,
+// so it is suited to long-lived streams such as sockets and files.
+// A Decoder can be passed to all Unpack functions as an io.Reader.
+type Decoder struct {
+	rd    io.Reader // reader provided by the client
+	buf   []byte
+	r, w  int  // read and write positions in buf
+	ahead bool // whether the decoder may read beyond the requested bytes
+
+	read  int64 // number of bytes read from rd
+	depth int   // nesting depth of arrays and maps being decoded
+	start int64 // offset of the top-level array or map being decoded
+
+	tagName              string
+	noEncodingMarshalers bool
+	strict               bool
+	merge                bool
+	naming               *NamingStrategy
+	foldCase             bool
+	limits               Limits
+
+	unionKey string // discriminator key of a union, which is not unknown to the next struct
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+// The Decoder introduces its own buffering and may read data from r
+// beyond the msgpack values requested.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		rd:      r,
+		buf:     make([]byte, defaultBufSize),
+		ahead:   true,
+		tagName: defaultTagName,
+	}
+}
+
+// decoderOf returns r itself if it is already a Decoder.
+// Otherwise, it returns a new Decoder with the default options that never reads
+// beyond the requested bytes, so that r is left just after the decoded value.
+func decoderOf(r io.Reader) *Decoder {
+	if d, ok := r.(*Decoder); ok {
+		return d
+	}
+	return &Decoder{rd: r, tagName: defaultTagName}
+}
+
+// DecoderOf returns r itself if it is already a Decoder.
+// Otherwise, it returns a new Decoder with the default options that never reads
+// beyond the requested bytes. It is useful for the methods which read values with
+// the Read methods of the Decoder from an io.Reader.
+func DecoderOf(r io.Reader) *Decoder {
+	return decoderOf(r)
+}
+
+// SetCustomStructTag sets the name of the struct field tag used by the Decoder.
+// The default is "msgp". For example, "json" makes the Decoder honor json tags.
+func (d *Decoder) SetCustomStructTag(tag string) {
+	d.tagName = tag
+}
+
+// SetNamingStrategy sets the strategy converting the Go names of the struct fields without
+// a name in the tag to the keys, e.g. SnakeCase. The default is nil, and the Go names are used.
+func (d *Decoder) SetNamingStrategy(naming *NamingStrategy) {
+	d.naming = naming
+}
+
+// UseCaseInsensitiveKeys sets whether the keys of a map match the names of the struct fields
+// case-insensitively, like encoding/json does. An exact match is preferred. The default is false.
+func (d *Decoder) UseCaseInsensitiveKeys(on bool) {
+	d.foldCase = on
+}
+
+// CaseInsensitiveKeys reports whether the Decoder matches the keys case-insensitively.
+// It is useful for the DecodeMsgpack methods which read struct values by themselves.
+func (d *Decoder) CaseInsensitiveKeys() bool {
+	return d.foldCase
+}
+
+// UseEncodingMarshalers sets whether the Decoder honors encoding.BinaryUnmarshaler and
+// encoding.TextUnmarshaler for the types without msgp-specific methods. The default is true.
+func (d *Decoder) UseEncodingMarshalers(on bool) {
+	d.noEncodingMarshalers = !on
+}
+
+// UseStrictMode sets whether the Decoder rejects the keys of a map that don't match any
+// field of the struct being decoded. If on, an *UnknownFieldsError listing the keys is returned
+// after the whole map is read. The default is false, and the unknown keys are skipped.
+func (d *Decoder) UseStrictMode(on bool) {
+	d.strict = on
+}
+
+// StrictMode reports whether the Decoder is in strict mode.
+// It is useful for the DecodeMsgpack methods which read struct values by themselves.
+func (d *Decoder) StrictMode() bool {
+	return d.strict
+}
+
+// UseMergeMode sets whether the Decoder merges the values read into the existing values,
+// like encoding/json does, instead of replacing them. If on:
+//
+//   - The fields of a struct which are absent from the input keep their values.
+//   - The keys and values read from a map are inserted into the existing map, if not nil.
+//   - The elements read from an array are read into the existing slice, reusing its backing
+//     array if the capacity allows. The elements beyond the existing length are zeroed first.
+//     The length of the slice is set to the number of the elements read.
+//   - A value is read into the value pointed by an existing non-nil pointer.
+//
+// A nil value still sets the zero value. The default is false, and a new value is made for
+// each value read.
+func (d *Decoder) UseMergeMode(on bool) {
+	d.merge = on
+}
+
+// MergeMode reports whether the Decoder is in merge mode.
+// It is useful for the DecodeMsgpack methods which read struct values by themselves.
+func (d *Decoder) MergeMode() bool {
+	return d.merge
+}
+
+// SetLimits sets the limits for the values decoded by the Decoder.
+// A zero field of 'limits' means the default limit. See Limits for the defaults.
+// If a value exceeds a limit, a *LimitError is returned.
+func (d *Decoder) SetLimits(limits Limits) {
+	d.limits = limits
+}
+
+// Decode reads the next msgpack value from its input and stores it in the value pointed to by v.
+// It returns io.EOF if there is no more value in the input.
+func (d *Decoder) Decode(v interface{}) error {
+	return Unpack(d, v)
+}
+
+// More reports whether there is another value in the input stream.
+func (d *Decoder) More() bool {
+	_, err := d.peekByte()
+	return err == nil
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's buffer.
+// The reader is valid until the next call to Decode.
+func (d *Decoder) Buffered() io.Reader {
+	return bytes.NewReader(d.buf[d.r:d.w])
+}
+
+// Read reads raw bytes from the input stream. Buffered data is returned first.
+func (d *Decoder) Read(p []byte) (int, error) {
+	if d.r < d.w {
+		n := copy(p, d.buf[d.r:d.w])
+		d.r += n
+		return n, nil
+	}
+	n, err := d.rd.Read(p)
+	d.read += int64(n)
+	return n, err
+}
+
+// fill makes sure that at least n bytes are buffered.
+// The buffer grows as the data arrives, so that a large n doesn't allocate
+// much more memory than the input actually has.
+func (d *Decoder) fill(n int) error {
+	if err := d.checkBytes(n); err != nil {
+		return err
+	}
+	if d.w-d.r >= n {
+		return nil
+	}
+
+	if d.r > 0 { // slide existing data to the beginning.
+		copy(d.buf, d.buf[d.r:d.w])
+		d.w -= d.r
+		d.r = 0
+	}
+
+	for filled := d.w; d.w < n; {
+		if d.w == len(d.buf) {
+			size := 2 * len(d.buf)
+			if size < minBufSize {
+				size = minBufSize
+			}
+			if size > n && !d.ahead {
+				size = n
+			}
+			buf := make([]byte, size)
+			copy(buf, d.buf[:d.w])
+			d.buf = buf
+		}
+
+		max := len(d.buf)
+		if !d.ahead && max > n {
+			max = n
+		}
+		read, err := io.ReadAtLeast(d.rd, d.buf[d.w:max], 1)
+		d.w += read
+		d.read += int64(read)
+		if err != nil {
+			if err == io.EOF && d.w > filled {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// discard skips the next n bytes. Unlike next, it doesn't need a buffer of n bytes.
+func (d *Decoder) discard(n int) error {
+	for n > 0 {
+		if d.r == d.w {
+			size := n
+			if size > defaultBufSize {
+				size = defaultBufSize
+			}
+			if err := d.fill(size); err != nil && d.r == d.w {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+		}
+
+		skip := d.w - d.r
+		if skip > n {
+			skip = n
+		}
+		d.r += skip
+		n -= skip
+	}
+	return nil
+}
+
+// peekByte returns the next byte without advancing the reader.
+func (d *Decoder) peekByte() (byte, error) {
+	if err := d.fill(1); err != nil {
+		return 0, err
+	}
+	return d.buf[d.r], nil
+}
+
+// readByte reads a byte. It returns io.EOF if there is no more byte.
+func (d *Decoder) readByte() (byte, error) {
+	if err := d.fill(1); err != nil {
+		return 0, err
+	}
+	b := d.buf[d.r]
+	d.r++
+	return b, nil
+}
+
+// next returns a slice of the next n bytes and advances the reader.
+// The slice is only valid until the next read.
+func (d *Decoder) next(n int) ([]byte, error) {
+	if err := d.fill(n); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	p := d.buf[d.r : d.r+n]
+	d.r += n
+	return p, nil
+}
+
+func (d *Decoder) readUint16() (uint16, error) {
+	p, err := d.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(p), nil
+}
+
+func (d *Decoder) readUint32() (uint32, error) {
+	p, err := d.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(p), nil
+}
+
+func (d *Decoder) readUint64() (uint64, error) {
+	p, err := d.next(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(p), nil
+}
+
+// fillValue makes sure that the value at the reader is buffered,
+// not including the elements of an array or a map.
+func (d *Decoder) fillValue() error {
+	if err := d.fill(1); err != nil {
+		return err
+	}
+	head := d.buf[d.r]
+	if err := d.fill(headerSize(head)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if err := d.checkHeaderLimits(); err != nil {
+		return err
+	}
+	for {
+		size, err := valueSize(d.buf[d.r:d.w])
+		if err != nil || size <= d.w-d.r {
+			return err
+		}
+		if err = d.fill(size); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+}
+
+// checkHeaderLimits checks the length in the header of the value at the reader, which is buffered.
+func (d *Decoder) checkHeaderLimits() error {
+	head := d.buf[d.r]
+	switch {
+	case (head >= 0xc4 && head <= 0xc9) || (head >= 0xd9 && head <= 0xdb): // bin, ext, str
+		size, err := valueSize(d.buf[d.r:d.w])
+		if err != nil {
+			return err
+		}
+		return d.checkStringLen(size - headerSize(head))
+	case head&0xe0 == 0x80 || (head >= 0xdc && head <= 0xdf): // array, map
+		size, _, _, err := readHeaderBytes(d.buf[d.r:d.w])
+		if err != nil {
+			return err
+		}
+		return d.checkContainerLen(size)
+	}
+	return nil
+}
+
+// advance moves the reader to the remaining bytes returned by a Read...Bytes function.
+func (d *Decoder) advance(rest []byte) {
+	d.r = d.w - len(rest)
+}
+
+// The Read methods read a value with the same rules as the Read...Bytes functions.
+// If the value is not of the format family expected, nothing is read.
+
+// ReadNil reads a nil value and returns true if the next value is nil.
+// Otherwise, it returns false and nothing is read.
+func (d *Decoder) ReadNil() (bool, error) {
+	if err := d.fill(1); err != nil {
+		return false, err
+	}
+	isNil, rest, err := ReadNilBytes(d.buf[d.r:d.w])
+	d.advance(rest)
+	return isNil, err
+}
+
+// ReadBool reads a bool value. A nil value is read as false.
+func (d *Decoder) ReadBool() (bool, error) {
+	if err := d.fillValue(); err != nil {
+		return false, err
+	}
+	v, rest, err := ReadBoolBytes(d.buf[d.r:d.w])
+	d.advance(rest)
+	return v, d.withOffset(err)
+}
+
+// ReadInt reads a value of the int, uint or float format family as an int64.
+// A nil value is read as 0.
+func (d *Decoder) ReadInt() (int64, error) {
+	if err := d.fillValue(); err != nil {
+		return 0, err
+	}
+	v, rest, err := ReadIntBytes(d.buf[d.r:d.w])
+	d.advance(rest)
+	return v, d.withOffset(err)
+}
+
+// ReadUint reads a value of the int, uint or float format family as a uint64.
+// A nil value is read as 0.
+func (d *Decoder) ReadUint() (uint64, error) {
+	if err := d.fillValue(); err != nil {
+		return 0, err
+	}
+	v, rest, err := ReadUintBytes(d.buf[d.r:d.w])
+	d.advance(rest)
+	return v, d.withOffset(err)
+}
+
+// ReadFloat reads a value of the int, uint or float format family as a float64.
+// A nil value is read as 0.
+func (d *Decoder) ReadFloat() (float64, error) {
+	if err := d.fillValue(); err != nil {
+		return 0, err
+	}
+	v, rest, err := ReadFloatBytes(d.buf[d.r:d.w])
+	d.advance(rest)
+	return v, d.withOffset(err)
+}
+
+// ReadString reads a value of the str or bin format family as a string.
+// A nil value is read as an empty string.
+func (d *Decoder) ReadString() (string, error) {
+	if err := d.fillValue(); err != nil {
+		return "", err
+	}
+	v, rest, err := ReadStringBytes(d.buf[d.r:d.w])
+	d.advance(rest)
+	return v, d.withOffset(err)
+}
+
+// ReadBin reads a value of the bin format family. A nil value is read as a nil slice.
+func (d *Decoder) ReadBin() ([]byte, error) {
+	if err := d.fillValue(); err != nil {
+		return nil, err
+	}
+	data, rest, err := ReadBinBytes(d.buf[d.r:d.w])
+	d.advance(rest)
+	if data != nil {
+		data = append([]byte(nil), data...)
+	}
+	return data, d.withOffset(err)
+}
+
+// ReadArrayHeader reads the header of an array and returns the number of elements.
+// A nil value is read as an empty array.
+func (d *Decoder) ReadArrayHeader() (int, error) {
+	if err := d.fillValue(); err != nil {
+		return 0, err
+	}
+	size, rest, err := ReadArrayHeaderBytes(d.buf[d.r:d.w])
+	d.advance(rest)
+	return size, d.withOffset(err)
+}
+
+// ReadMapHeader reads the header of a map and returns the number of key-value pairs.
+// A nil value is read as an empty map.
+func (d *Decoder) ReadMapHeader() (int, error) {
+	if err := d.fillValue(); err != nil {
+		return 0, err
+	}
+	size, rest, err := ReadMapHeaderBytes(d.buf[d.r:d.w])
+	d.advance(rest)
+	return size, d.withOffset(err)
+}
+
+// ReadStructHeader reads the header of a map or an array that a struct value is packed into.
+// It returns the number of key-value pairs of a map, or the number of elements of an array.
+// A nil value is read as an empty map.
+func (d *Decoder) ReadStructHeader() (size int, isArray bool, err error) {
+	if err = d.fillValue(); err != nil {
+		return 0, false, err
+	}
+	size, kind, rest, err := readHeaderBytes(d.buf[d.r:d.w])
+	d.advance(rest)
+	return size, kind == 0x90, d.withOffset(err)
+}
+
+// ReadStructKey reads a key of a map that a struct value is packed into. A key of the str or
+// bin format family is returned with isStr true. A key of other types can't match any field;
+// it is returned with isStr false, formatted with fmt.Sprint in strict mode for the
+// UnknownFieldsError, and empty otherwise.
+func (d *Decoder) ReadStructKey() (key string, isStr bool, err error) {
+	head, err := d.peekByte()
+	if err != nil {
+		return "", false, err
+	}
+	if isStrOrBinHead(head) {
+		key, err = d.ReadString()
+		return key, true, err
+	}
+	if !d.strict {
+		return "", false, Skip(d)
+	}
+	var v interface{}
+	if err = Unpack(d, &v); err != nil {
+		return "", false, err
+	}
+	return fmt.Sprint(v), false, nil
+}
+
+// Skip reads a value of any format family and discards it. See Skip function.
+func (d *Decoder) Skip() error {
+	return Skip(d)
+}
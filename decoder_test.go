@@ -0,0 +1,236 @@
+package msgp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func ExampleDecoder() {
+	var buf bytes.Buffer
+
+	enc := NewEncoder(&buf)
+	enc.Encode(1)
+	enc.Encode("aaa")
+	enc.Encode([]int{1, 2, 3})
+
+	dec := NewDecoder(&buf)
+	for dec.More() {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			fmt.Println(err)
+			break
+		}
+		fmt.Printf("%v\n", v)
+	}
+
+	// Output:
+	// 1
+	// aaa
+	// [1 2 3]
+}
+
+// oneByteReader returns at most one byte per Read call.
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (r oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return r.r.Read(p[:1])
+}
+
+func TestDecoderShortReads(t *testing.T) {
+	var buf bytes.Buffer
+
+	Pack(&buf, "1234567890123456789012345678901234567890")
+	Pack(&buf, 3.14)
+	Pack(&buf, []byte{1, 2, 3, 4, 5})
+
+	dec := NewDecoder(oneByteReader{&buf})
+
+	var str string
+	var f float64
+	var bin []byte
+	if err := dec.Decode(&str); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&f); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&bin); err != nil {
+		t.Fatal(err)
+	}
+	if str != "1234567890123456789012345678901234567890" || f != 3.14 || !bytes.Equal(bin, []byte{1, 2, 3, 4, 5}) {
+		t.Errorf("decoded %q, %v, %v", str, f, bin)
+	}
+
+	if err := dec.Decode(&f); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+}
+
+func TestDecoderTruncatedValue(t *testing.T) {
+	var str string
+
+	dec := NewDecoder(bytes.NewReader([]byte{0xa5, 'a', 'b'}))
+	if err := dec.Decode(&str); err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestUnpackDoesNotReadAhead(t *testing.T) {
+	var buf bytes.Buffer
+	var i int
+
+	Pack(&buf, 1)
+	buf.WriteString("rest")
+
+	if err := Unpack(&buf, &i); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "rest" {
+		t.Errorf("remaining %q, want %q", buf.String(), "rest")
+	}
+}
+
+func TestDecoderBuffered(t *testing.T) {
+	var buf bytes.Buffer
+	var i int
+
+	Pack(&buf, 1)
+	buf.WriteString("rest")
+
+	dec := NewDecoder(&buf)
+	if err := dec.Decode(&i); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := io.ReadAll(io.MultiReader(dec.Buffered(), &buf))
+	if string(rest) != "rest" {
+		t.Errorf("remaining %q, want %q", rest, "rest")
+	}
+}
+
+func TestDecoderCustomStructTag(t *testing.T) {
+	type myStruct struct {
+		AAA int `json:"a"`
+	}
+
+	var st myStruct
+	dec := NewDecoder(bytes.NewReader([]byte{0x81, 0xa1, 'a', 0x01}))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.AAA != 1 {
+		t.Errorf("AAA = %d, want 1", st.AAA)
+	}
+}
+
+func ExampleDecoder_ReadStructHeader() {
+	var buf bytes.Buffer
+
+	PackMapHeader(&buf, 3)
+	PackString(&buf, "id")
+	PackInt(&buf, -7)
+	PackString(&buf, "data")
+	PackBin(&buf, []byte{1, 2})
+	PackString(&buf, "unknown")
+	Pack(&buf, []int{1, 2, 3})
+
+	dec := DecoderOf(&buf)
+	size, isArray, _ := dec.ReadStructHeader()
+	fmt.Println(size, isArray)
+	for inx := 0; inx < size; inx++ {
+		key, _ := dec.ReadString()
+		switch key {
+		case "id":
+			id, _ := dec.ReadInt()
+			fmt.Println(key, id)
+		case "data":
+			data, _ := dec.ReadBin()
+			fmt.Println(key, data)
+		default:
+			dec.Skip()
+			fmt.Println(key, "skipped")
+		}
+	}
+
+	// Output:
+	// 3 false
+	// id -7
+	// data [1 2]
+	// unknown skipped
+}
+
+func TestDecoderReadMethods(t *testing.T) {
+	var buf bytes.Buffer
+
+	Pack(&buf, nil)
+	Pack(&buf, uint64(0xffffffffffffffff))
+	Pack(&buf, 1.5)
+	Pack(&buf, -3)
+	Pack(&buf, true)
+	Pack(&buf, []byte("bin"))
+	Pack(&buf, []int{})
+
+	dec := DecoderOf(&buf)
+	if isNil, err := dec.ReadNil(); !isNil || err != nil {
+		t.Fatalf("ReadNil() = %v, %v", isNil, err)
+	}
+	if isNil, err := dec.ReadNil(); isNil || err != nil {
+		t.Fatalf("ReadNil() = %v, %v", isNil, err)
+	}
+	if u, err := dec.ReadUint(); u != 0xffffffffffffffff || err != nil {
+		t.Fatalf("ReadUint() = %v, %v", u, err)
+	}
+	if i, err := dec.ReadInt(); i != 1 || err != nil { // float is converted.
+		t.Fatalf("ReadInt() = %v, %v", i, err)
+	}
+	if f, err := dec.ReadFloat(); f != -3 || err != nil {
+		t.Fatalf("ReadFloat() = %v, %v", f, err)
+	}
+	if _, err := dec.ReadString(); err == nil {
+		t.Fatal("ReadString() reads a bool")
+	}
+	if b, err := dec.ReadBool(); !b || err != nil { // nothing is read by the failed ReadString().
+		t.Fatalf("ReadBool() = %v, %v", b, err)
+	}
+	if s, err := dec.ReadString(); s != "bin" || err != nil {
+		t.Fatalf("ReadString() = %v, %v", s, err)
+	}
+	if _, err := dec.ReadMapHeader(); err == nil {
+		t.Fatal("ReadMapHeader() reads an array")
+	}
+}
+
+func TestDecoderReadStructKey(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		var buf bytes.Buffer
+		Pack(&buf, "a")
+		Pack(&buf, []byte("b"))
+		Pack(&buf, 12)
+		Pack(&buf, nil)
+		Pack(&buf, "next")
+
+		dec := NewDecoder(&buf)
+		dec.UseStrictMode(strict)
+		for _, want := range []struct {
+			key   string
+			isStr bool
+		}{{"a", true}, {"b", true}, {"12", false}, {"<nil>", false}} {
+			if !strict && !want.isStr {
+				want.key = ""
+			}
+			if key, isStr, err := dec.ReadStructKey(); key != want.key || isStr != want.isStr || err != nil {
+				t.Errorf("strict %v: ReadStructKey() = %q, %v, %v; want %q", strict, key, isStr, err, want.key)
+			}
+		}
+		if s, err := dec.ReadString(); s != "next" || err != nil {
+			t.Errorf("strict %v: stream is out of sync: %q, %v", strict, s, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package msgp
+
+import (
+	"reflect"
+)
+
+// zeroer is implemented by the types which define their own zero values, e.g. time.Time.
+type zeroer interface {
+	IsZero() bool
+}
+
+var zeroerType = reflect.TypeOf((*zeroer)(nil)).Elem()
+
+// isEmptyValue reports whether a field value is omitted by the omitempty option.
+// The rules are the same as encoding/json: false, 0, a nil pointer, a nil interface value,
+// and an array, slice, map, or string of length zero are empty. A struct is never empty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// zeroFunc returns the function which reports whether a value of 'typ' is omitted by the
+// omitzero option. If the type has an 'IsZero() bool' method, the method decides it, and a nil
+// pointer or interface value is always zero. Otherwise, the zero value of the type is zero.
+func zeroFunc(typ reflect.Type) func(v reflect.Value) bool {
+	switch {
+	case typ.Kind() == reflect.Interface && typ.Implements(zeroerType):
+		return func(v reflect.Value) bool {
+			return v.IsNil() || (v.Elem().Kind() == reflect.Ptr && v.Elem().IsNil()) || v.Interface().(zeroer).IsZero()
+		}
+	case typ.Kind() == reflect.Ptr && typ.Implements(zeroerType):
+		return func(v reflect.Value) bool {
+			return v.IsNil() || v.Interface().(zeroer).IsZero()
+		}
+	case typ.Implements(zeroerType):
+		return func(v reflect.Value) bool {
+			return v.Interface().(zeroer).IsZero()
+		}
+	case reflect.PtrTo(typ).Implements(zeroerType):
+		return func(v reflect.Value) bool {
+			if !v.CanAddr() { // the method has a pointer receiver.
+				addressable := reflect.New(v.Type()).Elem()
+				addressable.Set(v)
+				v = addressable
+			}
+			return v.Addr().Interface().(zeroer).IsZero()
+		}
+	}
+	return reflect.Value.IsZero
+}
+
+// IsEmpty reports whether the value pointed to by 'ptr' is omitted by the omitempty option.
+// It is used by the code generated by msgpgen for the types it cannot inspect.
+func IsEmpty(ptr interface{}) bool {
+	return isEmptyValue(reflect.ValueOf(ptr).Elem())
+}
+
+// IsZero reports whether the value pointed to by 'ptr' is omitted by the omitzero option.
+// It is used by the code generated by msgpgen for the types it cannot inspect.
+func IsZero(ptr interface{}) bool {
+	v := reflect.ValueOf(ptr).Elem()
+	return zeroFunc(v.Type())(v)
+}
@@ -0,0 +1,224 @@
+package msgp
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type zeroByMethod struct {
+	N int
+}
+
+func (z zeroByMethod) IsZero() bool { return z.N < 0 }
+
+type zeroByPtrMethod struct {
+	N int
+}
+
+func (z *zeroByPtrMethod) IsZero() bool { return z.N < 0 }
+
+// pointerTo returns a pointer to a copy of 'value'.
+func pointerTo(value interface{}) interface{} {
+	ptr := reflect.New(reflect.TypeOf(value))
+	ptr.Elem().Set(reflect.ValueOf(value))
+	return ptr.Interface()
+}
+
+func ExampleFieldProps_omitzero() {
+	type event struct {
+		Name string    `msgp:"name,omitempty"`
+		Tags []string  `msgp:"tags,omitempty"`
+		At   time.Time `msgp:"at,omitzero"`
+	}
+
+	var buf bytes.Buffer
+	Pack(&buf, event{Name: "start", Tags: []string{}})
+	fmt.Printf("% x\n", buf.Bytes())
+
+	// Output:
+	// 81 a4 6e 61 6d 65 a5 73 74 61 72 74
+}
+
+func TestIsEmpty(t *testing.T) {
+	type inner struct{ X int }
+	one := 1
+
+	tests := []struct {
+		value interface{}
+		empty bool
+	}{
+		{false, true},
+		{true, false},
+		{int8(0), true},
+		{int64(-1), false},
+		{uint16(0), true},
+		{uint(1), false},
+		{float32(0), true},
+		{0.5, false},
+		{"", true},
+		{"a", false},
+		{[]int(nil), true},
+		{[]int{}, true},
+		{[]int{0}, false},
+		{[]byte{}, true},
+		{map[string]int(nil), true},
+		{map[string]int{}, true},
+		{map[string]int{"a": 0}, false},
+		{[0]int{}, true},
+		{[1]int{}, false},
+		{(*int)(nil), true},
+		{&one, false},
+		{(*inner)(nil), true},
+		{&inner{}, false},
+		{inner{}, false}, // a struct is never empty.
+		{time.Time{}, false},
+		{zeroByMethod{N: -1}, false},
+		{func() {}, false},
+	}
+
+	for _, test := range tests {
+		if got := IsEmpty(pointerTo(test.value)); got != test.empty {
+			t.Errorf("IsEmpty(%T(%v)) = %v, want %v", test.value, test.value, got, test.empty)
+		}
+	}
+
+	var any interface{}
+	if !IsEmpty(&any) {
+		t.Error("nil interface value is not empty")
+	}
+	any = 0
+	if IsEmpty(&any) {
+		t.Error("interface value holding 0 is empty")
+	}
+}
+
+func TestIsZero(t *testing.T) {
+	type inner struct{ X int }
+
+	tests := []struct {
+		value interface{}
+		zero  bool
+	}{
+		{false, true},
+		{true, false},
+		{int8(0), true},
+		{uint(1), false},
+		{0.0, true},
+		{"", true},
+		{"a", false},
+		{[]int(nil), true},
+		{[]int{}, false}, // an empty slice is not the zero value.
+		{map[string]int(nil), true},
+		{map[string]int{}, false},
+		{[2]int{}, true},
+		{[2]int{0, 1}, false},
+		{(*int)(nil), true},
+		{new(int), false},
+		{inner{}, true},
+		{inner{X: 1}, false},
+		{time.Time{}, true},
+		{time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{zeroByMethod{N: 0}, false}, // decided by the method.
+		{zeroByMethod{N: -1}, true},
+		{(*zeroByMethod)(nil), true},
+		{&zeroByMethod{N: -1}, true},
+		{&zeroByMethod{N: 0}, false},
+		{zeroByPtrMethod{N: -1}, true},
+		{zeroByPtrMethod{N: 0}, false},
+	}
+
+	for _, test := range tests {
+		if got := IsZero(pointerTo(test.value)); got != test.zero {
+			t.Errorf("IsZero(%T(%v)) = %v, want %v", test.value, test.value, got, test.zero)
+		}
+	}
+
+	// interface types with the IsZero method
+	var z zeroer
+	if !IsZero(&z) {
+		t.Error("nil interface value is not zero")
+	}
+	z = (*zeroByMethod)(nil)
+	if !IsZero(&z) {
+		t.Error("interface value holding a nil pointer is not zero")
+	}
+	z = zeroByMethod{N: -1}
+	if !IsZero(&z) {
+		t.Error("IsZero method is not used for interface value")
+	}
+	z = zeroByMethod{N: 0}
+	if IsZero(&z) {
+		t.Error("IsZero method is not used for interface value")
+	}
+}
+
+func TestPackOmitEmptyAndZero(t *testing.T) {
+	type inner struct{ X int }
+	type all struct {
+		B   bool              `msgp:",omitempty"`
+		I   int               `msgp:",omitempty"`
+		U   uint8             `msgp:",omitempty"`
+		F   float64           `msgp:",omitempty"`
+		S   string            `msgp:",omitempty"`
+		Sl  []int             `msgp:",omitempty"`
+		M   map[string]string `msgp:",omitempty"`
+		Arr [0]int            `msgp:",omitempty"`
+		P   *inner            `msgp:",omitempty"`
+		Any interface{}       `msgp:",omitempty"`
+		St  inner             `msgp:",omitempty"`
+		T   time.Time         `msgp:",omitempty"`
+
+		ZSl  []int           `msgp:",omitzero"`
+		ZSt  inner           `msgp:",omitzero"`
+		ZT   time.Time       `msgp:",omitzero"`
+		ZM   zeroByMethod    `msgp:",omitzero"`
+		ZPM  zeroByPtrMethod `msgp:",omitzero"`
+		Both []int           `msgp:",omitempty,omitzero"`
+	}
+
+	keys := func(value interface{}) []string {
+		var buf bytes.Buffer
+		if err := Pack(&buf, value); err != nil { // must not panic with slices and maps.
+			t.Fatal(err)
+		}
+		var m map[string]interface{}
+		if err := Unpack(&buf, &m); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, sf := range cachedStructInfo(reflect.TypeOf(all{}), "msgp", nil).fields {
+			if _, ok := m[sf.Props.Name]; ok {
+				keys = append(keys, sf.Props.Name)
+			}
+		}
+		return keys
+	}
+
+	got := keys(all{Sl: []int{}, M: map[string]string{}, ZSl: []int{}, ZM: zeroByMethod{N: -1}, ZPM: zeroByPtrMethod{N: -1}, Both: []int{}})
+	want := []string{"St", "T", "ZSl"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// the value of the struct is not addressable.
+	got = keys(all{B: true, I: -1, U: 1, F: 0.1, S: "s", Sl: []int{0}, M: map[string]string{"": ""}, P: &inner{}, Any: 0,
+		ZSt: inner{X: 1}, ZT: time.Now(), Both: []int{1}})
+	want = []string{"B", "I", "U", "F", "S", "Sl", "M", "P", "Any", "St", "T", "ZSt", "ZT", "ZM", "ZPM", "Both"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// the fields are kept in array encoded structs.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.UseArrayEncodedStructs(true)
+	if err := enc.Encode(&all{}); err != nil {
+		t.Fatal(err)
+	}
+	if size, _, err := ReadArrayHeaderBytes(buf.Bytes()); err != nil || size != 18 {
+		t.Errorf("array encoded struct: size %d, err %v", size, err)
+	}
+}
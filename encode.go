@@ -1,8 +1,6 @@
 package msgp
 
 import (
-	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -13,51 +11,83 @@ import (
 // Pack writes a value to the io.Writer.
 // It is recommended to use this function for all types.
 func Pack(w io.Writer, value interface{}) error {
-	var err error
-
 	if value == nil {
-		err = PackNil(w)
+		return PackNil(w)
+	}
+
+	return encodeTo(w, func(e *Encoder) error {
+		return packValue(e, reflect.ValueOf(value))
+	})
+}
+
+// packValue writes a value to the Encoder.
+// Marshaler and StreamMarshaler are honored at every level. If 'v' is addressable,
+// the methods with pointer receiver are also honored.
+// encoding.BinaryMarshaler and encoding.TextMarshaler are honored
+// if no msgp-specific method or extension is available.
+func packValue(e *Encoder, v reflect.Value) error {
+	if !v.IsValid() {
+		return PackNil(e)
+	}
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return PackNil(e)
+		}
+		if v.Kind() == reflect.Interface && v.NumMethod() > 0 {
+			if u := unionInfoOf(v.Type()); u != nil {
+				tag, ok := lookupType(u.byType, v.Elem().Type())
+				if !ok {
+					return fmt.Errorf("msgp: type %v is not a case of the union %v", v.Elem().Type(), v.Type())
+				}
+				return packUnion(e, u, tag, v.Elem())
+			}
+			if name, ok := registeredTypeName(v.Elem().Type()); ok {
+				return packRegisteredType(e, name, v.Elem())
+			}
+		}
+		return packValue(e, v.Elem()) // methods of pointer are found through the addressable element.
+	}
+
+	if ok, err := packMarshaler(e, v); ok {
+		return err
 	}
 
-	switch reflect.ValueOf(value).Kind() {
+	if v.Type() == extensionType {
+		return PackExtension(e, v.Interface().(Extension))
+	}
+	if info := extInfoByType(v.Type()); info != nil {
+		return packRegisteredExt(e, info, v.Interface())
+	}
+
+	if !e.noEncodingMarshalers {
+		if ok, err := packEncodingMarshaler(e, v); ok {
+			return err
+		}
+	}
+
+	var err error
+
+	switch v.Kind() {
 	case reflect.Bool:
-		err = PackBool(w, value.(bool))
-	case reflect.Int:
-		err = PackInt(w, int64(value.(int)))
-	case reflect.Int8:
-		err = PackInt(w, int64(value.(int8)))
-	case reflect.Int16:
-		err = PackInt(w, int64(value.(int16)))
-	case reflect.Int32:
-		err = PackInt(w, int64(value.(int32)))
-	case reflect.Int64:
-		err = PackInt(w, value.(int64))
-	case reflect.Uint:
-		err = PackUint(w, uint64(value.(uint)))
-	case reflect.Uint8:
-		err = PackUint(w, uint64(value.(uint8)))
-	case reflect.Uint16:
-		err = PackUint(w, uint64(value.(uint16)))
-	case reflect.Uint32:
-		err = PackUint(w, uint64(value.(uint32)))
-	case reflect.Uint64:
-		err = PackUint(w, value.(uint64))
+		err = PackBool(e, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		err = PackInt(e, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		err = PackUint(e, v.Uint())
 	case reflect.Float32:
-		err = PackFloat32(w, value.(float32))
+		err = PackFloat32(e, float32(v.Float()))
 	case reflect.Float64:
-		err = PackFloat64(w, value.(float64))
+		err = PackFloat64(e, v.Float())
 	case reflect.String:
-		err = PackString(w, value.(string))
+		err = PackString(e, v.String())
 	case reflect.Array, reflect.Slice:
-		err = PackArray(w, value)
+		err = packArray(e, v)
 	case reflect.Map:
-		err = PackMap(w, value)
+		err = packMap(e, v)
 	case reflect.Struct:
-		err = PackStruct(w, value)
-	case reflect.Ptr:
-		err = PackPtr(w, value)
+		err = packStruct(e, v)
 	default:
-		err = errors.New("msgp: unsupported type value")
+		err = &UnsupportedTypeError{Type: v.Type()}
 	}
 
 	return err
@@ -65,441 +95,286 @@ func Pack(w io.Writer, value interface{}) error {
 
 // PackNil writes a nil value to the io.Writer.
 func PackNil(w io.Writer) error {
-	_, err := w.Write([]byte{0xc0})
-	return err
+	return writeAppend(w, func(b []byte) []byte {
+		return AppendNil(b)
+	})
 }
 
 // PackBool writes a bool value to the io.Writer.
 func PackBool(w io.Writer, value bool) error {
-	var err error
-
-	if value {
-		_, err = w.Write([]byte{0xc3})
-	} else {
-		_, err = w.Write([]byte{0xc2})
-	}
-
-	return err
+	return writeAppend(w, func(b []byte) []byte {
+		return AppendBool(b, value)
+	})
 }
 
 // PackInt writes an integer value to the io.Writer.
 func PackInt(w io.Writer, value int64) error {
-	var err error
-	var buf bytes.Buffer
-
-	if value >= 0 {
-		if value <= 0x7f { // int
-			if err = buf.WriteByte(byte(value)); err != nil {
-				return err
-			}
-		} else if value <= 0xff { // uint
-			if err = buf.WriteByte(0xcc); err != nil {
-				return err
-			}
-			if err = buf.WriteByte(uint8(value)); err != nil {
-				return err
-			}
-		} else if value <= 0x7fff { // int
-			if err = buf.WriteByte(0xd1); err != nil {
-				return err
-			}
-			if err = binary.Write(&buf, binary.BigEndian, int16(value)); err != nil {
-				return err
-			}
-		} else if value <= 0xffff { // uint
-			if err = buf.WriteByte(0xcd); err != nil {
-				return err
-			}
-			if err = binary.Write(&buf, binary.BigEndian, uint16(value)); err != nil {
-				return err
-			}
-		} else if value <= 0x7fffffff { // int
-			if err = buf.WriteByte(0xd2); err != nil {
-				return err
-			}
-			if err = binary.Write(&buf, binary.BigEndian, int32(value)); err != nil {
-				return err
-			}
-		} else if value <= 0xffffffff { // uint
-			if err = buf.WriteByte(0xce); err != nil {
-				return err
-			}
-			if err = binary.Write(&buf, binary.BigEndian, uint32(value)); err != nil {
-				return err
-			}
-		} else { // int
-			if err = buf.WriteByte(0xd3); err != nil {
-				return err
-			}
-			if err = binary.Write(&buf, binary.BigEndian, int64(value)); err != nil {
-				return err
-			}
-		}
-	} else {
-		if value >= -32 {
-			if err = buf.WriteByte(byte(value)); err != nil {
-				return err
-			}
-		} else if value >= -0x80 {
-			if err = buf.WriteByte(0xd0); err != nil {
-				return err
-			}
-			if err = buf.WriteByte(byte(value)); err != nil {
-				return err
-			}
-		} else if value >= -0x8000 {
-			if err = buf.WriteByte(0xd1); err != nil {
-				return err
-			}
-			if err = binary.Write(&buf, binary.BigEndian, int16(value)); err != nil {
-				return err
-			}
-		} else if value >= -0x80000000 {
-			if err = buf.WriteByte(0xd2); err != nil {
-				return err
-			}
-			if err = binary.Write(&buf, binary.BigEndian, int32(value)); err != nil {
-				return err
-			}
-		} else {
-			if err = buf.WriteByte(0xd3); err != nil {
-				return err
-			}
-			if err = binary.Write(&buf, binary.BigEndian, int64(value)); err != nil {
-				return err
-			}
-		}
-	}
-
-	_, err = w.Write(buf.Bytes())
-	return err
+	return writeAppend(w, func(b []byte) []byte {
+		return AppendInt(b, value)
+	})
 }
 
 // PackUint writes an unsigned integer value to the io.Writer.
+// If w is an Encoder in canonical mode, a value which fits in int64 is packed as PackInt does.
 func PackUint(w io.Writer, value uint64) error {
-	var err error
-	var buf bytes.Buffer
-
-	if value <= 0xff {
-		if err = buf.WriteByte(0xcc); err != nil {
-			return err
-		}
-		if err = buf.WriteByte(uint8(value)); err != nil {
-			return err
-		}
-	} else if value <= 0xffff {
-		if err = buf.WriteByte(0xcd); err != nil {
-			return err
-		}
-		if err = binary.Write(&buf, binary.BigEndian, uint16(value)); err != nil {
-			return err
-		}
-	} else if value <= 0xffffffff {
-		if err = buf.WriteByte(0xce); err != nil {
-			return err
-		}
-		if err = binary.Write(&buf, binary.BigEndian, uint32(value)); err != nil {
-			return err
-		}
-	} else {
-		if err = buf.WriteByte(0xcf); err != nil {
-			return err
-		}
-		if err = binary.Write(&buf, binary.BigEndian, value); err != nil {
-			return err
-		}
+	if value <= math.MaxInt64 && isCanonical(w) {
+		return PackInt(w, int64(value))
 	}
-
-	_, err = w.Write(buf.Bytes())
-	return err
+	return writeAppend(w, func(b []byte) []byte {
+		return AppendUint(b, value)
+	})
 }
 
 // PackFloat32 writes a float32 value to the io.Writer.
+// If w is an Encoder in canonical mode, NaN is packed as the float32 quiet NaN.
 func PackFloat32(w io.Writer, value float32) error {
-	var err error
-	var buf bytes.Buffer
-
-	if err = buf.WriteByte(0xca); err != nil {
-		return err
-	}
-	if err = binary.Write(&buf, binary.BigEndian, math.Float32bits(value)); err != nil {
-		return err
+	if value != value && isCanonical(w) { // NaN
+		value = float32(math.NaN())
 	}
-
-	_, err = w.Write(buf.Bytes())
-	return err
+	return writeAppend(w, func(b []byte) []byte {
+		return AppendFloat32(b, value)
+	})
 }
 
 // PackFloat64 writes a float64 value to the io.Writer.
+// If w is an Encoder in canonical mode, a value exactly representable as a float32 is packed
+// as a float32, and NaN is packed as the float32 quiet NaN.
 func PackFloat64(w io.Writer, value float64) error {
-	var err error
-	var buf bytes.Buffer
-
-	if err = buf.WriteByte(0xcb); err != nil {
-		return err
-	}
-	if err = binary.Write(&buf, binary.BigEndian, math.Float64bits(value)); err != nil {
-		return err
+	if isCanonical(w) {
+		if math.IsNaN(value) {
+			return PackFloat32(w, float32(math.NaN()))
+		} else if float64(float32(value)) == value {
+			return PackFloat32(w, float32(value))
+		}
 	}
-
-	_, err = w.Write(buf.Bytes())
-	return err
+	return writeAppend(w, func(b []byte) []byte {
+		return AppendFloat64(b, value)
+	})
 }
 
 // PackString writes a string value to the io.Writer.
 func PackString(w io.Writer, value string) error {
-	var err error
-	var buf bytes.Buffer
-
-	len := len(value)
-	if len <= 0x1f {
-		if err = buf.WriteByte(0xa0 | uint8(len)); err != nil {
-			return err
-		}
-		if _, err = buf.WriteString(value); err != nil {
-			return err
-		}
-	} else if len <= 0xff {
-		if err = buf.WriteByte(0xd9); err != nil {
-			return err
-		}
-		if err = buf.WriteByte(uint8(len)); err != nil {
-			return err
-		}
-		if _, err = buf.WriteString(value); err != nil {
-			return err
-		}
-	} else if len <= 0xffff {
-		if err = buf.WriteByte(0xda); err != nil {
-			return err
-		}
-		if err = binary.Write(&buf, binary.BigEndian, uint16(len)); err != nil {
-			return err
-		}
-		if _, err = buf.WriteString(value); err != nil {
-			return err
-		}
-	} else if len <= 0xffffffff {
-		if err = buf.WriteByte(0xdb); err != nil {
-			return err
-		}
-		if err = binary.Write(&buf, binary.BigEndian, uint32(len)); err != nil {
-			return err
-		}
-		if _, err = buf.WriteString(value); err != nil {
-			return err
-		}
-	} else {
+	if uint64(len(value)) > math.MaxUint32 {
 		return errors.New("msgp: try to pack too long string")
 	}
+	return writeAppend(w, func(b []byte) []byte {
+		return AppendString(b, value)
+	})
+}
 
-	_, err = w.Write(buf.Bytes())
-	return err
+// PackBin writes a byte slice as a value of the bin format family to the io.Writer.
+// It produces the same bytes as PackArray with a []byte value.
+func PackBin(w io.Writer, value []byte) error {
+	if uint64(len(value)) > math.MaxUint32 {
+		return errors.New("msgp: try to pack too long bin")
+	}
+	return writeAppend(w, func(b []byte) []byte {
+		return AppendBin(b, value)
+	})
+}
+
+// PackArrayHeader writes the header of an array with 'size' elements to the io.Writer.
+// The elements should be written after the header.
+func PackArrayHeader(w io.Writer, size int) error {
+	if err := checkHeaderSize(size); err != nil {
+		return err
+	}
+	return writeAppend(w, func(b []byte) []byte {
+		return AppendArrayHeader(b, size)
+	})
+}
+
+// PackMapHeader writes the header of a map with 'size' key-value pairs to the io.Writer.
+// The keys and values should be written alternately after the header.
+func PackMapHeader(w io.Writer, size int) error {
+	if err := checkHeaderSize(size); err != nil {
+		return err
+	}
+	return writeAppend(w, func(b []byte) []byte {
+		return AppendMapHeader(b, size)
+	})
+}
+
+func checkHeaderSize(size int) error {
+	if size < 0 {
+		return errors.New("msgp: negative size of array or map")
+	} else if uint64(size) > math.MaxUint32 {
+		return errors.New("msgp: try to pack too large array or map")
+	}
+	return nil
 }
 
 // PackArray writes an array to the io.Writer.
 func PackArray(w io.Writer, value interface{}) error {
+	return encodeTo(w, func(e *Encoder) error {
+		return packArray(e, reflect.ValueOf(value))
+	})
+}
+
+func packArray(e *Encoder, a reflect.Value) error {
 	var err error
-	var buf bytes.Buffer
-
-	if reflect.TypeOf(value).Elem().Kind() == reflect.Uint8 { // for []byte
-		b := reflect.ValueOf(value)
-		arraySize := b.Len()
-		if arraySize <= 0xff {
-			if err = buf.WriteByte(0xc4); err != nil {
-				return err
-			}
-			if err = binary.Write(&buf, binary.BigEndian, uint8(arraySize)); err != nil {
-				return err
-			}
-		} else if arraySize <= 0xffff {
-			if err = buf.WriteByte(0xc5); err != nil {
-				return err
-			}
-			if err = binary.Write(&buf, binary.BigEndian, uint16(arraySize)); err != nil {
-				return err
-			}
-		} else if arraySize <= 0xffffffff {
-			if err = buf.WriteByte(0xc6); err != nil {
-				return err
-			}
-			if err = binary.Write(&buf, binary.BigEndian, uint32(arraySize)); err != nil {
-				return err
-			}
-		}
 
-		if _, err = w.Write(buf.Bytes()); err != nil {
-			return err
+	if a.Type().Elem().Kind() == reflect.Uint8 { // for []byte
+		if a.Kind() == reflect.Array && !a.CanAddr() { // Bytes() needs an addressable array.
+			c := reflect.New(a.Type()).Elem()
+			c.Set(a)
+			a = c
 		}
-
-		_, err = w.Write(value.([]byte))
-		return err
+		return PackBin(e, a.Bytes())
 	}
 
-	a := reflect.ValueOf(value)
-	arraySize := a.Len()
-	if arraySize <= 0x0f {
-		if err = buf.WriteByte(0x90 | uint8(arraySize)); err != nil {
-			return err
-		}
-	} else if arraySize <= 0xffff {
-		if err = buf.WriteByte(0xdc); err != nil {
-			return err
-		}
-		if err = binary.Write(&buf, binary.BigEndian, uint16(arraySize)); err != nil {
-			return err
-		}
-	} else if arraySize <= 0xffffffff {
-		if err = buf.WriteByte(0xdd); err != nil {
-			return err
-		}
-		if err = binary.Write(&buf, binary.BigEndian, uint32(arraySize)); err != nil {
-			return err
-		}
+	if err = PackArrayHeader(e, a.Len()); err != nil {
+		return err
 	}
 
 	for inx := 0; inx < a.Len(); inx++ {
-		if err = Pack(&buf, a.Index(inx).Interface()); err != nil {
+		if err = packValue(e, a.Index(inx)); err != nil {
 			return err
 		}
 	}
 
-	_, err = w.Write(buf.Bytes())
-	return err
+	return nil
 }
 
 // PackMap writes a map to the io.Writer.
 func PackMap(w io.Writer, value interface{}) error {
+	return encodeTo(w, func(e *Encoder) error {
+		return packMap(e, reflect.ValueOf(value))
+	})
+}
+
+func packMap(e *Encoder, m reflect.Value) error {
 	var err error
-	var buf bytes.Buffer
 
-	m := reflect.ValueOf(value)
-	mapSize := m.Len()
-	if mapSize <= 0x0f {
-		if err = buf.WriteByte(0x80 | uint8(mapSize)); err != nil {
-			return err
-		}
-	} else if mapSize <= 0xffff {
-		if err = buf.WriteByte(0xde); err != nil {
-			return err
-		}
-		if err = binary.Write(&buf, binary.BigEndian, uint16(mapSize)); err != nil {
-			return err
-		}
-	} else if mapSize <= 0xffffffff {
-		if err = buf.WriteByte(0xdf); err != nil {
-			return err
-		}
-		if err = binary.Write(&buf, binary.BigEndian, uint32(mapSize)); err != nil {
-			return err
-		}
+	if e.canonical {
+		return packMapCanonical(e, m)
+	}
+
+	if err = PackMapHeader(e, m.Len()); err != nil {
+		return err
 	}
 
 	for _, key := range m.MapKeys() {
-		if err = Pack(&buf, key.Interface()); err != nil {
+		if err = packValue(e, key); err != nil {
 			return err
 		}
-		if err = Pack(&buf, m.MapIndex(key).Interface()); err != nil {
+		if err = packValue(e, m.MapIndex(key)); err != nil {
 			return err
 		}
 	}
 
-	_, err = w.Write(buf.Bytes())
-	return err
+	return nil
 }
 
 // PackStruct writes a struct value to the io.Writer.
 // The struct value is serialized as a map[string]interface{}.
+// If the struct type has a marker field '_ struct{} `msgp:",asarray"`' or the Encoder is set to
+// UseArrayEncodedStructs(true), the struct value is serialized as an array of the field values.
 func PackStruct(w io.Writer, value interface{}) error {
-	var err error
-	var headBuf bytes.Buffer
-	var dataBuf bytes.Buffer
+	return encodeTo(w, func(e *Encoder) error {
+		return packStruct(e, reflect.ValueOf(value))
+	})
+}
 
-	structTyp := reflect.TypeOf(value)
-	structVal := reflect.ValueOf(value)
-	structNumField := structTyp.NumField()
+func packStruct(e *Encoder, structVal reflect.Value) error {
+	si := cachedStructInfo(structVal.Type(), e.tagName, e.naming)
+	if e.structAsArray || si.asArray {
+		return packStructAsArray(e, structVal, si.fields)
+	}
+	return packStructAsMap(e, structVal, si, "", "")
+}
 
-	numField := uint32(0)
-	for inx := 0; inx < structNumField; inx++ {
-		var fp FieldProps
+// packStructAsMap writes a struct value as a map of the field names and values.
+// If 'key' is not empty, the key and 'value' are written first as the discriminator of a union,
+// and the field with the name of the key, if any, is skipped.
+func packStructAsMap(e *Encoder, structVal reflect.Value, si *structInfo, key, value string) error {
+	var err error
 
-		field := structTyp.Field(inx)
-		fp.parseTag(field)
-		if fp.Skip {
-			continue
+	numField := 0
+	for inx := range si.fields {
+		if _, ok := packedFieldValue(e, structVal, &si.fields[inx]); ok && (key == "" || si.fields[inx].Props.Name != key) {
+			numField++
 		}
+	}
+	if key != "" {
+		numField++
+	}
 
-		fieldValue := structVal.Field(inx)
-		if fp.OmitEmpty {
-			if fieldValue.Interface() == reflect.Zero(fieldValue.Type()).Interface() {
-				continue
-			}
-		}
+	if err = PackMapHeader(e, numField); err != nil {
+		return err
+	}
 
-		if err = PackString(&dataBuf, fp.Name); err != nil {
+	if key != "" {
+		if err = PackString(e, key); err != nil {
 			return err
 		}
-
-		if fp.String {
-			if fieldValue.Interface() == nil {
-				err = PackString(&dataBuf, "nil")
-			} else {
-			Loop:
-				for {
-					switch fieldValue.Kind() {
-					case reflect.Bool,
-						reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-						reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
-						reflect.Float32, reflect.Float64:
-						err = PackString(&dataBuf, fmt.Sprintf("%v", fieldValue.Interface()))
-						break Loop
-					case reflect.Ptr:
-						fieldValue = fieldValue.Elem()
-					default:
-						err = fmt.Errorf("msgp: cannot pack Go struct field %v.%s of type %v into string", structTyp, field.Name, field.Type)
-						break Loop
-					}
-				}
-			}
-		} else {
-			err = Pack(&dataBuf, fieldValue.Interface())
-		}
-		if err != nil {
+		if err = PackString(e, value); err != nil {
 			return err
 		}
-
-		numField++
 	}
 
-	if numField <= 0x0f {
-		if err = headBuf.WriteByte(0x80 | uint8(numField)); err != nil {
-			return err
-		}
-	} else if numField <= 0xffff {
-		if err = headBuf.WriteByte(0xde); err != nil {
-			return err
-		}
-		if err = binary.Write(&headBuf, binary.BigEndian, uint16(numField)); err != nil {
-			return err
+	for inx := range si.fields {
+		sf := &si.fields[inx]
+		fieldValue, ok := packedFieldValue(e, structVal, sf)
+		if !ok || (key != "" && sf.Props.Name == key) {
+			continue
 		}
-	} else if numField <= 0xffffffff {
-		if err = headBuf.WriteByte(0xdf); err != nil {
+		if err = PackString(e, sf.Props.Name); err != nil {
 			return err
 		}
-		if err = binary.Write(&headBuf, binary.BigEndian, uint32(numField)); err != nil {
+		if err = sf.pack(e, fieldValue); err != nil {
 			return err
 		}
 	}
 
-	if _, err = w.Write(headBuf.Bytes()); err != nil {
+	return nil
+}
+
+// packedFieldValue returns the value of a field to be packed into a map.
+// It returns false if the field is omitted.
+func packedFieldValue(e *Encoder, structVal reflect.Value, sf *structField) (reflect.Value, bool) {
+	fieldValue, ok := fieldByIndex(structVal, sf.Index)
+	if !ok { // nil embedded pointer
+		return fieldValue, false
+	}
+	if sf.Props.OmitEmpty && (isEmptyValue(fieldValue) || (e.defaultAsEmpty && sf.Props.HasDefault && sf.isDefault(fieldValue))) {
+		return fieldValue, false
+	}
+	if sf.Props.OmitZero && sf.isZero(fieldValue) {
+		return fieldValue, false
+	}
+	return fieldValue, true
+}
+
+// packStructAsArray writes a struct value as an array of the field values in field order.
+// The omitempty and omitzero options are ignored to keep the positions of the fields,
+// and the fields of a nil embedded pointer are written as nil.
+func packStructAsArray(e *Encoder, structVal reflect.Value, fields []structField) error {
+	var err error
+
+	if err = PackArrayHeader(e, len(fields)); err != nil {
 		return err
 	}
 
-	_, err = w.Write(dataBuf.Bytes())
-	return err
+	for inx := range fields {
+		sf := &fields[inx]
+		fieldValue, ok := fieldByIndex(structVal, sf.Index)
+		if !ok { // nil embedded pointer
+			err = PackNil(e)
+		} else {
+			err = sf.pack(e, fieldValue)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // PackPtr writes a value pointed by ptr to the io.Writer.
 func PackPtr(w io.Writer, ptr interface{}) error {
-	return Pack(w, reflect.ValueOf(ptr).Elem().Interface())
+	return encodeTo(w, func(e *Encoder) error {
+		return packValue(e, reflect.ValueOf(ptr).Elem())
+	})
 }
@@ -312,3 +312,70 @@ func ExamplePack_pointer() {
 	// 81 a3 61 61 61 01
 	// 86 a3 41 41 41 aa 31 32 33 34 35 36 37 38 39 30 a3 42 42 42 cc ff a3 63 63 63 a5 31 32 33 34 35 a1 5f 22 a3 47 47 47 33 a3 48 48 48 a3 31 30 30
 }
+
+type userID int64
+type status string
+type flags []uint8
+type level uint8
+
+func (l level) String() string {
+	return fmt.Sprintf("level-%d", uint8(l))
+}
+
+func ExamplePack_definedTypes() {
+	type myStruct struct {
+		ID     userID
+		Status status
+		Level  level   `msgp:",string"`
+		Parent *userID `msgp:",string"`
+	}
+
+	var buf bytes.Buffer
+
+	Pack(&buf, userID(0x7fff))
+	Pack(&buf, status("ok"))
+	Pack(&buf, flags{1, 2})
+	Pack(&buf, [2]level{3, 4})
+	Pack(&buf, map[status]userID{"a": 1})
+	fmt.Printf("% x\n", buf.Bytes())
+
+	buf.Reset()
+
+	Pack(&buf, myStruct{1, "ok", 2, nil})
+	fmt.Printf("% x\n", buf.Bytes())
+
+	// Output:
+	// d1 7f ff a2 6f 6b c4 02 01 02 c4 02 03 04 81 a1 61 01
+	// 84 a2 49 44 01 a6 53 74 61 74 75 73 a2 6f 6b a5 4c 65 76 65 6c a1 32 a6 50 61 72 65 6e 74 a3 6e 69 6c
+}
+
+type point3 struct {
+	_ struct{} `msgp:",asarray"`
+	X int
+	Y int
+	Z int `msgp:",omitempty"`
+}
+
+func ExamplePack_structAsArray() {
+	type myStruct struct {
+		Name  string
+		Skip  string `msgp:"-"`
+		Count int    `msgp:",string"`
+	}
+
+	var buf bytes.Buffer
+
+	Pack(&buf, point3{X: 1, Y: 2})
+	fmt.Printf("% x\n", buf.Bytes())
+
+	buf.Reset()
+
+	enc := NewEncoder(&buf)
+	enc.UseArrayEncodedStructs(true)
+	enc.Encode(myStruct{"a", "b", 3})
+	fmt.Printf("% x\n", buf.Bytes())
+
+	// Output:
+	// 93 01 02 00
+	// 92 a1 61 a1 33
+}
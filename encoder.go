@@ -0,0 +1,167 @@
+package msgp
+
+import (
+	"io"
+	"sync"
+)
+
+// Encoder writes msgpack values to an output stream.
+// An Encoder keeps its options and its internal buffer between calls,
+// so it is suited to long-lived streams such as sockets and files.
+// An Encoder can be passed to all Pack functions as an io.Writer.
+type Encoder struct {
+	w     io.Writer // writer provided by the client
+	buf   []byte
+	depth int // nesting level of the Pack functions in progress
+
+	tagName              string
+	noEncodingMarshalers bool
+	structAsArray        bool
+	canonical            bool
+	defaultAsEmpty       bool
+	naming               *NamingStrategy
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:       w,
+		buf:     make([]byte, 0, defaultBufSize),
+		tagName: defaultTagName,
+	}
+}
+
+// SetCustomStructTag sets the name of the struct field tag used by the Encoder.
+// The default is "msgp". For example, "json" makes the Encoder honor json tags.
+func (e *Encoder) SetCustomStructTag(tag string) {
+	e.tagName = tag
+}
+
+// SetNamingStrategy sets the strategy converting the Go names of the struct fields without
+// a name in the tag to the keys, e.g. SnakeCase. The default is nil, and the Go names are used.
+func (e *Encoder) SetNamingStrategy(naming *NamingStrategy) {
+	e.naming = naming
+}
+
+// UseEncodingMarshalers sets whether the Encoder honors encoding.BinaryMarshaler and
+// encoding.TextMarshaler for the types without msgp-specific methods. The default is true.
+func (e *Encoder) UseEncodingMarshalers(on bool) {
+	e.noEncodingMarshalers = !on
+}
+
+// UseArrayEncodedStructs sets whether the Encoder packs all struct values as arrays of
+// the field values in field order, instead of maps with the field names. The default is false.
+// Struct types with the marker field '_ struct{} `msgp:",asarray"`' are always packed as arrays.
+func (e *Encoder) UseArrayEncodedStructs(on bool) {
+	e.structAsArray = on
+}
+
+// ArrayEncodedStructs reports whether the Encoder packs all struct values as arrays.
+// It is useful for the EncodeMsgpack methods which write struct values by themselves.
+func (e *Encoder) ArrayEncodedStructs() bool {
+	return e.structAsArray
+}
+
+// UseCanonicalEncoding sets whether the Encoder produces canonical output, the same bytes
+// for the same values: the keys of maps are sorted in a defined order, and the smallest format
+// is chosen for every number. See canonical.go for the rules. The default is false, and the keys
+// of maps are packed in the random order of Go maps.
+func (e *Encoder) UseCanonicalEncoding(on bool) {
+	e.canonical = on
+}
+
+// UseDefaultAsEmpty sets whether the omitempty option also omits a field whose value equals
+// the value of its default option, e.g. Port of 8080 for `msgp:"port,omitempty,default=8080"`.
+// Such a field is unpacked to the same value from the input without the key. The default is false.
+func (e *Encoder) UseDefaultAsEmpty(on bool) {
+	e.defaultAsEmpty = on
+}
+
+// DefaultAsEmpty reports whether the Encoder omits the fields with their default values.
+// It is useful for the EncodeMsgpack methods which write struct values by themselves.
+func (e *Encoder) DefaultAsEmpty() bool {
+	return e.defaultAsEmpty
+}
+
+// Encode writes the msgpack encoding of v to the stream.
+// Nothing is written to the stream if v cannot be encoded.
+func (e *Encoder) Encode(v interface{}) error {
+	return Pack(e, v)
+}
+
+// Write writes raw bytes to the stream.
+// While a value is being packed, the bytes are buffered until the value is complete.
+func (e *Encoder) Write(p []byte) (int, error) {
+	if e.depth == 0 {
+		return e.w.Write(p)
+	}
+	e.buf = append(e.buf, p...)
+	return len(p), nil
+}
+
+// EncodeTo calls fn with an Encoder writing to w. If w is already an Encoder, it is used as is,
+// with its options. The bytes written to the Encoder are buffered and written to w at once when
+// the outermost call returns, and nothing is written if fn returns an error. It is useful for the
+// methods which write a value with the Pack functions, such as the MarshalMsg methods generated
+// by msgpgen. fn must not retain the Encoder.
+func EncodeTo(w io.Writer, fn func(e *Encoder) error) error {
+	return encodeTo(w, fn)
+}
+
+// encoderPool keeps the Encoders used by encodeTo for the writers which are not Encoders.
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		return &Encoder{tagName: defaultTagName}
+	},
+}
+
+// maxPooledBufSize is the maximum capacity of the buffer of an Encoder kept in encoderPool.
+const maxPooledBufSize = 64 << 10
+
+// encodeTo calls fn with an Encoder writing to w. If w is already an Encoder, it is used as is.
+// The encoded bytes are buffered and written to the stream at once when the outermost call returns.
+// If an error occurs, nothing is written.
+func encodeTo(w io.Writer, fn func(e *Encoder) error) error {
+	e, ok := w.(*Encoder)
+	if !ok {
+		e = encoderPool.Get().(*Encoder)
+		e.w = w
+		defer func() {
+			if cap(e.buf) <= maxPooledBufSize {
+				*e = Encoder{buf: e.buf[:0], tagName: defaultTagName} // options may be changed by fn.
+				encoderPool.Put(e)
+			}
+		}()
+	}
+
+	e.depth++
+	err := fn(e)
+	e.depth--
+	if e.depth > 0 {
+		return err
+	}
+
+	if err == nil {
+		_, err = e.w.Write(e.buf)
+	}
+	e.buf = e.buf[:0]
+	return err
+}
+
+// writeAppend writes the bytes appended by 'fn' to w.
+// If w is an Encoder, the bytes are appended to its buffer directly.
+func writeAppend(w io.Writer, fn func(b []byte) []byte) error {
+	e, ok := w.(*Encoder)
+	if !ok {
+		_, err := w.Write(fn(nil))
+		return err
+	}
+
+	e.buf = fn(e.buf)
+	if e.depth > 0 {
+		return nil
+	}
+	_, err := e.w.Write(e.buf)
+	e.buf = e.buf[:0]
+	return err
+}
@@ -0,0 +1,126 @@
+package msgp
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func ExampleEncoder() {
+	var buf bytes.Buffer
+
+	enc := NewEncoder(&buf)
+	enc.Encode(1)
+	enc.Encode("aaa")
+	enc.Encode([]int{1, 2, 3})
+	fmt.Printf("% x\n", buf.Bytes())
+
+	// Output:
+	// 01 a3 61 61 61 93 01 02 03
+}
+
+type countingWriter struct {
+	writes int
+	buf    bytes.Buffer
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.buf.Write(p)
+}
+
+func TestEncoderWritesOnce(t *testing.T) {
+	var w countingWriter
+
+	enc := NewEncoder(&w)
+	if err := enc.Encode(map[string][]int{"a": {1, 2}, "b": {3}}); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 {
+		t.Errorf("writes = %d, want 1", w.writes)
+	}
+}
+
+func TestEncoderDiscardsOnError(t *testing.T) {
+	var buf bytes.Buffer
+
+	enc := NewEncoder(&buf)
+	if err := enc.Encode([]interface{}{1, "a", make(chan int)}); err == nil {
+		t.Fatal("Encode succeeded, want error")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("written % x, want nothing", buf.Bytes())
+	}
+	if err := enc.Encode(1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{0x01}) {
+		t.Errorf("written % x, want 01", buf.Bytes())
+	}
+}
+
+func TestEncodeTo(t *testing.T) {
+	var w countingWriter
+
+	err := EncodeTo(&w, func(e *Encoder) error {
+		if err := PackArrayHeader(e, 2); err != nil {
+			return err
+		}
+		if err := PackInt(e, 1); err != nil {
+			return err
+		}
+		return PackString(e, "a")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 1 || !bytes.Equal(w.buf.Bytes(), []byte{0x92, 0x01, 0xa1, 'a'}) {
+		t.Errorf("writes = %d, written % x", w.writes, w.buf.Bytes())
+	}
+
+	// nothing is written on error, and the options set by fn are not kept.
+	w = countingWriter{}
+	err = EncodeTo(&w, func(e *Encoder) error {
+		e.UseCanonicalEncoding(true)
+		PackUint(e, 1)
+		return Pack(e, make(chan int))
+	})
+	if err == nil || w.writes != 0 {
+		t.Errorf("err = %v, writes = %d", err, w.writes)
+	}
+	if err = EncodeTo(&w, func(e *Encoder) error { return PackUint(e, 1) }); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.buf.Bytes(), []byte{0xcc, 0x01}) {
+		t.Errorf("written % x, want cc 01", w.buf.Bytes())
+	}
+
+	// an Encoder is used as is, with its options.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.UseCanonicalEncoding(true)
+	if err = EncodeTo(enc, func(e *Encoder) error { return PackUint(e, 1) }); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), []byte{0x01}) {
+		t.Errorf("written % x, want 01", buf.Bytes())
+	}
+}
+
+func TestEncoderCustomStructTag(t *testing.T) {
+	type myStruct struct {
+		AAA int `json:"a"`
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode([]myStruct{{1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{0x91, 0x81, 0xa1, 'a', 0x01}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("written % x, want % x", buf.Bytes(), want)
+	}
+}
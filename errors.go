@@ -0,0 +1,149 @@
+package msgp
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// UnpackTypeError is returned by the Unpack functions when a msgpack value cannot be
+// assigned to a Go value. The Read methods of Decoder and the Read...Bytes functions
+// return it too, without the field path.
+type UnpackTypeError struct {
+	Format string       // name of the format of the msgpack value in the spec, e.g. "uint16" or "fixmap"
+	Type   reflect.Type // type of the Go value; nil for the Read...Header functions
+	Field  string       // path to the Go value from the top-level value, e.g. "Order.Items[3].Price"
+	Offset int64        // offset of the msgpack value from the beginning of the input
+	Reason string       // additional description, if any
+}
+
+func (e *UnpackTypeError) Error() string {
+	s := "msgp: cannot unpack " + e.Format
+	if e.Field != "" {
+		s += " into Go value " + e.Field
+	}
+	if e.Type != nil {
+		s += " of type " + e.Type.String()
+	}
+	s += fmt.Sprintf(" at offset %d", e.Offset)
+	if e.Reason != "" {
+		s += ": " + e.Reason
+	}
+	return s
+}
+
+// UnknownFieldsError is returned in strict mode when a map has keys that don't match
+// any field of the struct. For a struct read from an array, the indexes of the extra
+// elements are listed as the keys. The values of all keys are read before it is returned.
+type UnknownFieldsError struct {
+	Type  reflect.Type // type of the struct
+	Field string       // path to the struct from the top-level value; empty for the top-level value
+	Keys  []string     // the unknown keys in the order of the map
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return "msgp: unknown keys " + quoteKeys(e.Keys) + " for Go struct " + structDesc(e.Field, e.Type)
+}
+
+// MissingFieldsError is returned when the keys of the fields with the required option
+// are absent from a map, or the elements of them are absent from an array.
+type MissingFieldsError struct {
+	Type  reflect.Type // type of the struct
+	Field string       // path to the struct from the top-level value; empty for the top-level value
+	Keys  []string     // the keys of the missing fields in field order
+}
+
+func (e *MissingFieldsError) Error() string {
+	return "msgp: missing required keys " + quoteKeys(e.Keys) + " for Go struct " + structDesc(e.Field, e.Type)
+}
+
+func quoteKeys(keys []string) string {
+	quoted := make([]string, len(keys))
+	for inx, key := range keys {
+		quoted[inx] = strconv.Quote(key)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func structDesc(field string, typ reflect.Type) string {
+	if field == "" {
+		return typ.String()
+	}
+	return field + " of type " + typ.String()
+}
+
+// UnsupportedTypeError is returned by the Pack and Unpack functions when a Go value
+// of an unsupported type, such as a channel or a function, is given.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "msgp: unsupported type: " + e.Type.String()
+}
+
+// FormatName returns the name of the format beginning with 'head' in the msgpack spec,
+// e.g. "fixmap" for 0x82 and "uint16" for 0xcd.
+func FormatName(head byte) string {
+	switch {
+	case head <= 0x7f:
+		return "positive fixint"
+	case head <= 0x8f:
+		return "fixmap"
+	case head <= 0x9f:
+		return "fixarray"
+	case head <= 0xbf:
+		return "fixstr"
+	case head >= 0xe0:
+		return "negative fixint"
+	}
+	return formatNames[head-0xc0]
+}
+
+var formatNames = [...]string{
+	"nil", "(never used)", "false", "true",
+	"bin8", "bin16", "bin32", "ext8", "ext16", "ext32",
+	"float32", "float64", "uint8", "uint16", "uint32", "uint64",
+	"int8", "int16", "int32", "int64",
+	"fixext1", "fixext2", "fixext4", "fixext8", "fixext16",
+	"str8", "str16", "str32", "array16", "array32", "map16", "map32",
+}
+
+// typeError returns an UnpackTypeError for the value beginning with 'head'.
+func typeError(head byte, offset int64, typ reflect.Type, reason string) error {
+	return &UnpackTypeError{Format: FormatName(head), Type: typ, Offset: offset, Reason: reason}
+}
+
+// offset returns the offset of the next byte from the beginning of the input.
+func (d *Decoder) offset() int64 {
+	return d.read - int64(d.w-d.r)
+}
+
+// withOffset adds the offset of the value at the reader to an UnpackTypeError
+// returned by a Read...Bytes function.
+func (d *Decoder) withOffset(err error) error {
+	if e, ok := err.(*UnpackTypeError); ok {
+		e.Offset += d.offset()
+	}
+	return err
+}
+
+// pathError is implemented by the errors with the path to the Go value.
+type pathError interface {
+	fieldPath() *string
+}
+
+func (e *UnpackTypeError) fieldPath() *string    { return &e.Field }
+func (e *UnknownFieldsError) fieldPath() *string { return &e.Field }
+func (e *MissingFieldsError) fieldPath() *string { return &e.Field }
+
+// wrapField prepends the name of a field or an element to the field path of the error.
+// It is called on the way back from the error, so it costs nothing unless an error occurs.
+func wrapField(err error, elem string) error {
+	if e, ok := err.(pathError); ok {
+		path := e.fieldPath()
+		*path = elem + *path
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package msgp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type errItem struct {
+	Name  string
+	Price float64
+}
+
+type errOrder struct {
+	ID    int
+	Items []errItem
+	Tags  map[string]int
+}
+
+func ExampleUnpackTypeError() {
+	var buf bytes.Buffer
+	Pack(&buf, map[string]interface{}{
+		"Items": []interface{}{
+			map[string]interface{}{"Price": 1.5},
+			map[string]interface{}{"Price": "free"},
+		},
+	})
+
+	var order errOrder
+	err := Unpack(&buf, &order)
+
+	var te *UnpackTypeError
+	if errors.As(err, &te) {
+		fmt.Println(te.Field, te.Format, te.Type, te.Offset)
+	}
+
+	// Output:
+	// errOrder.Items[1].Price fixstr float64 31
+}
+
+func ExampleFormatName() {
+	for _, head := range []byte{0x05, 0x82, 0xa3, 0xc0, 0xcd, 0xd8, 0xff} {
+		fmt.Printf("%02x %s\n", head, FormatName(head))
+	}
+
+	// Output:
+	// 05 positive fixint
+	// 82 fixmap
+	// a3 fixstr
+	// c0 nil
+	// cd uint16
+	// d8 fixext16
+	// ff negative fixint
+}
+
+func TestUnpackTypeError(t *testing.T) {
+	tests := []struct {
+		value  interface{}
+		ptr    interface{}
+		format string
+		field  string
+		offset int64
+	}{
+		{"a", new(int), "fixstr", "", 0},
+		{[]interface{}{1, true}, new([]int), "true", "[1]", 2},
+		{[3]int{}, new([2]int), "fixarray", "", 0},
+		{map[string]interface{}{"k": 1.5}, new(map[string]string), "float64", "[k]", 3},
+		{map[string]interface{}{"ID": "x"}, new(errOrder), "fixstr", "errOrder.ID", 4},
+		{map[string]interface{}{"Tags": map[string]interface{}{"a": []int{1}}}, new(errOrder), "fixarray", "errOrder.Tags[a]", 9},
+		{[]interface{}{map[string]interface{}{"Items": 1}}, new([]errOrder), "positive fixint", "[0].Items", 8},
+		{map[string]interface{}{"A": "x"}, new(struct{ A int }), "fixstr", "A", 3},
+		{[]interface{}{"x"}, new(point3), "fixstr", "point3.X", 1},
+		{1, new(Extension), "positive fixint", "", 0},
+		{1, new(errOrder), "positive fixint", "", 0},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := Pack(&buf, test.value); err != nil {
+			t.Fatal(err)
+		}
+		err := Unpack(&buf, test.ptr)
+
+		var te *UnpackTypeError
+		if !errors.As(err, &te) {
+			t.Errorf("%v: got error %v, want an UnpackTypeError", test.value, err)
+			continue
+		}
+		if te.Format != test.format || te.Field != test.field || te.Offset != test.offset {
+			t.Errorf("%v: got %q %q %d, want %q %q %d", test.value,
+				te.Format, te.Field, te.Offset, test.format, test.field, test.offset)
+		}
+		if te.Type == nil {
+			t.Errorf("%v: no type in the error", test.value)
+		}
+	}
+}
+
+func TestUnpackTypeErrorStringOption(t *testing.T) {
+	var buf bytes.Buffer
+	Pack(&buf, map[string]string{"N": "abc"})
+
+	var v struct {
+		N int `msgp:",string"`
+	}
+	err := Unpack(&buf, &v)
+
+	var te *UnpackTypeError
+	if !errors.As(err, &te) || te.Field != "N" || te.Type.Kind() != reflect.Int || te.Reason == "" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestDecoderTypeErrorOffset(t *testing.T) {
+	var buf bytes.Buffer
+	Pack(&buf, "skipped")
+	Pack(&buf, []interface{}{1, "x"})
+
+	d := NewDecoder(&buf)
+	if err := d.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReadArrayHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReadInt(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := d.ReadInt()
+
+	var te *UnpackTypeError
+	if !errors.As(err, &te) || te.Offset != 10 || te.Format != "fixstr" {
+		t.Errorf("got error %v", err)
+	}
+	want := "msgp: cannot unpack fixstr of type int64 at offset 10"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
+
+func TestUnsupportedTypeError(t *testing.T) {
+	var ue *UnsupportedTypeError
+
+	err := Pack(&bytes.Buffer{}, []interface{}{make(chan int)})
+	if !errors.As(err, &ue) || ue.Type != reflect.TypeOf(make(chan int)) {
+		t.Errorf("Pack: got error %v", err)
+	}
+
+	var ch chan int
+	err = Unpack(bytes.NewReader([]byte{0xc0}), &ch)
+	if !errors.As(err, &ue) || ue.Type != reflect.TypeOf(ch) {
+		t.Errorf("Unpack: got error %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package msgp
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// Extension represents a value of the ext format family whose type code is not registered.
+// Type is the application-specific type code, and Data is the raw data of the value.
+type Extension struct {
+	Type int8
+	Data []byte
+}
+
+// ExtEncodeFunc returns the data of the extension value for v.
+type ExtEncodeFunc func(v interface{}) ([]byte, error)
+
+// ExtDecodeFunc decodes the data of an extension value into the value pointed by 'ptr'.
+type ExtDecodeFunc func(data []byte, ptr interface{}) error
+
+type extInfo struct {
+	extType int8
+	typ     reflect.Type
+	encode  ExtEncodeFunc
+	decode  ExtDecodeFunc
+}
+
+var extensionType = reflect.TypeOf(Extension{})
+
+var extRegistry = struct {
+	sync.RWMutex
+	byType map[reflect.Type]*extInfo
+	byCode map[int8]*extInfo
+}{
+	byType: make(map[reflect.Type]*extInfo),
+	byCode: make(map[int8]*extInfo),
+}
+
+// RegisterExt maps the extension type code 'extType' to the Go type of 'value'.
+// Values of the type are packed as extension values with the data returned by 'encode',
+// and extension values of the type code are unpacked into the type with 'decode'.
+// Type codes from -128 to -1 are reserved by the msgpack spec.
+// Registering the same type code or Go type again replaces the previous registration.
+func RegisterExt(extType int8, value interface{}, encode ExtEncodeFunc, decode ExtDecodeFunc) {
+	info := &extInfo{extType, reflect.TypeOf(value), encode, decode}
+
+	extRegistry.Lock()
+	defer extRegistry.Unlock()
+
+	if old, ok := extRegistry.byCode[extType]; ok {
+		delete(extRegistry.byType, old.typ)
+	}
+	if old, ok := extRegistry.byType[info.typ]; ok {
+		delete(extRegistry.byCode, old.extType)
+	}
+	extRegistry.byType[info.typ] = info
+	extRegistry.byCode[extType] = info
+
+	clearStructInfoCache()
+}
+
+func extInfoByType(typ reflect.Type) *extInfo {
+	extRegistry.RLock()
+	defer extRegistry.RUnlock()
+	return extRegistry.byType[typ]
+}
+
+func extInfoByCode(extType int8) *extInfo {
+	extRegistry.RLock()
+	defer extRegistry.RUnlock()
+	return extRegistry.byCode[extType]
+}
+
+// PackExtension writes an extension value to the io.Writer.
+// The smallest format of the ext format family is chosen for the length of data.
+func PackExtension(w io.Writer, ext Extension) error {
+	if uint64(len(ext.Data)) > math.MaxUint32 {
+		return fmt.Errorf("msgp: try to pack too long extension")
+	}
+	return writeAppend(w, func(b []byte) []byte {
+		return AppendExtension(b, ext)
+	})
+}
+
+// packRegisteredExt writes a value of a registered Go type as an extension value.
+func packRegisteredExt(w io.Writer, info *extInfo, value interface{}) error {
+	data, err := info.encode(value)
+	if err != nil {
+		return err
+	}
+	return PackExtension(w, Extension{info.extType, data})
+}
+
+// UnpackExtension reads an extension value from the io.Reader. And assigns it to the value pointed by 'ptr'.
+// 'ptr' should be a pointer of Extension.
+func UnpackExtension(r io.Reader, ptr interface{}) error {
+	var err error
+	var head byte
+
+	d := decoderOf(r)
+
+	offset := d.offset()
+	if head, err = d.readByte(); err != nil {
+		return err
+	}
+	if head == 0xc0 { // nil
+		reflect.ValueOf(ptr).Elem().Set(reflect.Zero(reflect.TypeOf(ptr).Elem()))
+		return nil
+	}
+	if !isExtHead(head) {
+		return typeError(head, offset, extensionType, "")
+	}
+
+	ext, err := unpackExtBody(d, head)
+	if err != nil {
+		return err
+	}
+
+	reflect.ValueOf(ptr).Elem().Set(reflect.ValueOf(ext))
+	return nil
+}
+
+// unpackRegisteredExt reads an extension value of the type code registered for the type pointed by 'ptr'.
+func unpackRegisteredExt(d *Decoder, info *extInfo, ptr interface{}) error {
+	var err error
+	var head byte
+
+	offset := d.offset()
+	if head, err = d.readByte(); err != nil {
+		return err
+	}
+	if head == 0xc0 { // nil
+		reflect.ValueOf(ptr).Elem().Set(reflect.Zero(reflect.TypeOf(ptr).Elem()))
+		return nil
+	}
+	if !isExtHead(head) {
+		return typeError(head, offset, info.typ, "")
+	}
+
+	ext, err := unpackExtBody(d, head)
+	if err != nil {
+		return err
+	}
+	if ext.Type != info.extType {
+		return typeError(head, offset, info.typ, fmt.Sprintf("extension type %d", ext.Type))
+	}
+
+	return info.decode(ext.Data, ptr)
+}
+
+// unpackExt reads the body of an extension value. If the type code is registered,
+// the value is decoded into the registered Go type. Otherwise, an Extension is returned.
+func unpackExt(d *Decoder, head byte) (interface{}, error) {
+	ext, err := unpackExtBody(d, head)
+	if err != nil {
+		return nil, err
+	}
+
+	info := extInfoByCode(ext.Type)
+	if info == nil {
+		return ext, nil
+	}
+
+	val := reflect.New(info.typ)
+	if err = info.decode(ext.Data, val.Interface()); err != nil {
+		return nil, err
+	}
+	return val.Elem().Interface(), nil
+}
+
+func isExtHead(head byte) bool {
+	return (head >= 0xd4 && head <= 0xd8) || (head >= 0xc7 && head <= 0xc9)
+}
+
+func unpackExtBody(d *Decoder, head byte) (Extension, error) {
+	var err error
+	var len int
+	var ext Extension
+
+	switch head {
+	case 0xd4:
+		len = 1
+	case 0xd5:
+		len = 2
+	case 0xd6:
+		len = 4
+	case 0xd7:
+		len = 8
+	case 0xd8:
+		len = 16
+	case 0xc7:
+		var temp uint8
+		if temp, err = d.readByte(); err != nil {
+			return ext, err
+		}
+		len = int(temp)
+	case 0xc8:
+		var temp uint16
+		if temp, err = d.readUint16(); err != nil {
+			return ext, err
+		}
+		len = int(temp)
+	case 0xc9:
+		var temp uint32
+		if temp, err = d.readUint32(); err != nil {
+			return ext, err
+		}
+		len = int(temp)
+	}
+
+	var typ byte
+	if typ, err = d.readByte(); err != nil {
+		return ext, err
+	}
+	ext.Type = int8(typ)
+
+	ext.Data, err = unpackBinBody(d, len)
+	return ext, err
+}
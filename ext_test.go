@@ -0,0 +1,125 @@
+package msgp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type testPoint struct {
+	X, Y int16
+}
+
+func init() {
+	RegisterExt(10, testPoint{},
+		func(v interface{}) ([]byte, error) {
+			p := v.(testPoint)
+			data := make([]byte, 4)
+			binary.BigEndian.PutUint16(data, uint16(p.X))
+			binary.BigEndian.PutUint16(data[2:], uint16(p.Y))
+			return data, nil
+		},
+		func(data []byte, ptr interface{}) error {
+			if len(data) != 4 {
+				return errors.New("invalid point")
+			}
+			p := ptr.(*testPoint)
+			p.X = int16(binary.BigEndian.Uint16(data))
+			p.Y = int16(binary.BigEndian.Uint16(data[2:]))
+			return nil
+		})
+}
+
+func ExamplePackExtension() {
+	var buf bytes.Buffer
+
+	PackExtension(&buf, Extension{1, []byte{1}})
+	PackExtension(&buf, Extension{1, []byte{1, 2}})
+	PackExtension(&buf, Extension{1, []byte{1, 2, 3, 4}})
+	PackExtension(&buf, Extension{1, []byte{1, 2, 3}})
+	PackExtension(&buf, Extension{-2, []byte{}})
+	fmt.Printf("% x\n", buf.Bytes())
+
+	// Output:
+	// d4 01 01 d5 01 01 02 d6 01 01 02 03 04 c7 03 01 01 02 03 c7 00 fe
+}
+
+func ExampleRegisterExt() {
+	var buf bytes.Buffer
+	var p testPoint
+	var unknown interface{}
+
+	Pack(&buf, testPoint{1, -1})
+	fmt.Printf("% x\n", buf.Bytes())
+
+	Unpack(bytes.NewReader(buf.Bytes()), &p)
+	fmt.Printf("%v\n", p)
+
+	Unpack(bytes.NewReader(buf.Bytes()), &unknown)
+	fmt.Printf("%T %v\n", unknown, unknown)
+
+	// Output:
+	// d6 0a 00 01 ff ff
+	// {1 -1}
+	// msgp.testPoint {1 -1}
+}
+
+func TestExtensionRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 2, 3, 4, 8, 16, 17, 0xff, 0x100, 0xffff, 0x10000} {
+		var buf bytes.Buffer
+
+		src := Extension{42, bytes.Repeat([]byte{0x5a}, size)}
+		if err := Pack(&buf, src); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+
+		var ext Extension
+		if err := Unpack(bytes.NewReader(buf.Bytes()), &ext); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if ext.Type != 42 || !bytes.Equal(ext.Data, src.Data) {
+			t.Errorf("size %d: unpacked type %d, %d bytes", size, ext.Type, len(ext.Data))
+		}
+
+		var unknown interface{}
+		if err := Unpack(bytes.NewReader(buf.Bytes()), &unknown); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if ext, ok := unknown.(Extension); !ok || ext.Type != 42 || !bytes.Equal(ext.Data, src.Data) {
+			t.Errorf("size %d: unpacked %T", size, unknown)
+		}
+	}
+}
+
+func TestRegisteredExtInStruct(t *testing.T) {
+	type shape struct {
+		Points []testPoint
+		Origin *testPoint
+	}
+
+	var buf bytes.Buffer
+	src := shape{[]testPoint{{1, 2}, {3, 4}}, &testPoint{5, 6}}
+	if err := Pack(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	var dst shape
+	if err := Unpack(&buf, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if len(dst.Points) != 2 || dst.Points[1] != (testPoint{3, 4}) || dst.Origin == nil || *dst.Origin != (testPoint{5, 6}) {
+		t.Errorf("unpacked %+v", dst)
+	}
+}
+
+func TestRegisteredExtTypeMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	var p testPoint
+
+	PackExtension(&buf, Extension{11, []byte{0, 1, 0, 1}})
+	if err := Unpack(&buf, &p); err == nil {
+		t.Error("Unpack succeeded, want error")
+	}
+}
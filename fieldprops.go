@@ -1,8 +1,12 @@
 package msgp
 
 import (
+	"fmt"
 	"reflect"
+	"sort"
 	"strings"
+
+	"github.com/shanpark/msgp/internal/tags"
 )
 
 // FieldProps represents field properties of struct for struct packing.
@@ -10,15 +14,23 @@ type FieldProps struct {
 	Name      string
 	Skip      bool
 	OmitEmpty bool
+	OmitZero  bool
 	String    bool
+	Required  bool
+
+	// Default is the value of the default option, e.g. "8080" for `msgp:"port,default=8080"`.
+	// It is assigned to the field when the key is absent from the input, with the same
+	// conversion as the string option. HasDefault reports whether the option is given.
+	Default    string
+	HasDefault bool
 }
 
-func (fp *FieldProps) parseTag(field reflect.StructField) {
-	tag := field.Tag.Get("msgp")
+func (fp *FieldProps) parseTag(field reflect.StructField, tagName string) {
+	tag := field.Tag.Get(tagName)
 	if tag == "" {
 		fp.Name = field.Name
 	} else {
-		name, opts := parseTag(tag)
+		name, opts := tags.Parse(tag)
 		if name == "-" {
 			if strings.TrimSpace(string(opts)) == "" {
 				fp.Skip = true
@@ -36,8 +48,214 @@ func (fp *FieldProps) parseTag(field reflect.StructField) {
 			fp.OmitEmpty = true
 		}
 
+		if opts.Contains("omitzero") {
+			fp.OmitZero = true
+		}
+
 		if opts.Contains("string") {
 			fp.String = true
 		}
+
+		if opts.Contains("required") {
+			fp.Required = true
+		}
+
+		fp.Default, fp.HasDefault = opts.Value("default")
+	}
+}
+
+// isStructAsArray reports whether the struct type has the marker field '_ struct{} `msgp:",asarray"`'
+// which makes the struct values serialized as arrays.
+func isStructAsArray(typ reflect.Type, tagName string) bool {
+	for inx := 0; inx < typ.NumField(); inx++ {
+		field := typ.Field(inx)
+		if field.Name == "_" {
+			if _, opts := tags.Parse(field.Tag.Get(tagName)); opts.Contains("asarray") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// structField represents a field to be packed or unpacked for a struct type.
+// The fields of embedded structs are promoted to the struct that embeds them.
+type structField struct {
+	Props  FieldProps
+	Name   string       // Go name of the field
+	Type   reflect.Type // Go type of the field
+	Index  []int        // index sequence for reflect.Value.FieldByIndex
+	tagged bool         // whether the name is given by the tag
+	seq    int          // position in structInfo.fields, set by newStructInfo
+
+	isZero func(v reflect.Value) bool // set by newStructInfo for the omitzero option
+
+	defaultValue reflect.Value // converted value of the default option, set by newStructInfo
+	defaultErr   error         // error of the conversion of the default option
+
+	pack   func(e *Encoder, v reflect.Value) error // set by newStructInfo
+	unpack func(d *Decoder, v reflect.Value) error // set by newStructInfo
+}
+
+// structFields returns the fields of a struct type to be packed or unpacked.
+// The names of the fields without a name in the tag are converted by 'naming', if not nil.
+// The fields of embedded structs (and embedded pointers to structs) are promoted with the
+// visibility and conflict rules of Go. If there are multiple fields with the same name at the
+// shallowest depth, the field with a name given by the tag wins, or all of them are ignored.
+// An embedded struct with a name given by the tag is treated as a normal field.
+func structFields(typ reflect.Type, tagName string, naming *NamingStrategy) []structField {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var fields []structField
+	var current []embedded
+	next := []embedded{{typ: typ}}
+	count := map[reflect.Type]int{}
+	nextCount := map[reflect.Type]int{typ: 1}
+	visited := map[reflect.Type]bool{}
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, s := range current {
+			if visited[s.typ] {
+				continue
+			}
+			visited[s.typ] = true
+
+			for inx := 0; inx < s.typ.NumField(); inx++ {
+				var fp FieldProps
+
+				field := s.typ.Field(inx)
+				fieldTyp := field.Type
+				if fieldTyp.Name() == "" && fieldTyp.Kind() == reflect.Ptr {
+					fieldTyp = fieldTyp.Elem()
+				}
+				if field.Anonymous {
+					if field.PkgPath != "" && fieldTyp.Kind() != reflect.Struct { // unexported non-struct
+						continue
+					}
+				} else if field.PkgPath != "" { // unexported
+					continue
+				}
+
+				fp.parseTag(field, tagName)
+				if fp.Skip {
+					continue
+				}
+
+				name, _ := tags.Parse(field.Tag.Get(tagName))
+				tagged := name != ""
+				if !tagged && naming != nil {
+					fp.Name = naming.FieldName(field.Name)
+				}
+
+				index := make([]int, len(s.index)+1)
+				copy(index, s.index)
+				index[len(s.index)] = inx
+
+				if field.Anonymous && !tagged && fieldTyp.Kind() == reflect.Struct && extInfoByType(fieldTyp) == nil {
+					nextCount[fieldTyp]++
+					if nextCount[fieldTyp] == 1 {
+						next = append(next, embedded{fieldTyp, index})
+					}
+					continue
+				}
+				if field.Anonymous && field.PkgPath != "" { // unexported struct can't be a field.
+					continue
+				}
+
+				fields = append(fields, structField{Props: fp, Name: field.Name, Type: field.Type, Index: index, tagged: tagged})
+				if count[s.typ] > 1 {
+					// The struct was embedded multiple times at the same level.
+					// Add the same field again so that it is annihilated by the conflict rule.
+					fields = append(fields, fields[len(fields)-1])
+				}
+			}
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		if fields[i].Props.Name != fields[j].Props.Name {
+			return fields[i].Props.Name < fields[j].Props.Name
+		}
+		if len(fields[i].Index) != len(fields[j].Index) {
+			return len(fields[i].Index) < len(fields[j].Index)
+		}
+		if fields[i].tagged != fields[j].tagged {
+			return fields[i].tagged
+		}
+		return indexLess(fields[i].Index, fields[j].Index)
+	})
+
+	// remove the fields hidden by the conflict rule.
+	out := fields[:0]
+	for inx, advance := 0, 0; inx < len(fields); inx += advance {
+		for advance = 1; inx+advance < len(fields); advance++ {
+			if fields[inx+advance].Props.Name != fields[inx].Props.Name {
+				break
+			}
+		}
+		if advance > 1 {
+			first, second := fields[inx], fields[inx+1]
+			if len(first.Index) == len(second.Index) && first.tagged == second.tagged {
+				continue
+			}
+		}
+		out = append(out, fields[inx])
+	}
+	fields = out
+
+	sort.Slice(fields, func(i, j int) bool {
+		return indexLess(fields[i].Index, fields[j].Index)
+	})
+	return fields
+}
+
+func indexLess(a, b []int) bool {
+	for inx, x := range a {
+		if inx >= len(b) {
+			return false
+		}
+		if x != b[inx] {
+			return x < b[inx]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// fieldByIndex returns the field of 'v' with the index sequence.
+// It returns false if one of the embedded pointers on the way is nil.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for inx, i := range index {
+		if inx > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
+// fieldByIndexAlloc returns the field of 'v' with the index sequence.
+// The nil embedded pointers on the way are allocated.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, error) {
+	for inx, i := range index {
+		if inx > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("msgp: cannot set embedded pointer to unexported struct: %v", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
 	}
+	return v, nil
 }
@@ -0,0 +1,342 @@
+package msgp
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type embeddedBase struct {
+	ID   int
+	Name string
+}
+
+type EmbeddedAudit struct {
+	Name    string // conflicts with embeddedBase.Name at the same depth.
+	Created int
+}
+
+type embeddedTagged struct {
+	Name string `msgp:"Name"` // wins over embeddedBase.Name by the tag.
+}
+
+type embeddedInner struct {
+	Deep int
+}
+
+type embeddedPtr struct {
+	*embeddedInner
+	Value int
+}
+
+type embeddedOuter struct {
+	embeddedBase
+	*EmbeddedAudit
+	Own  string
+	ID   int           // hides embeddedBase.ID
+	Meta embeddedInner `msgp:"meta"`
+}
+
+func fieldNames(fields []structField) []string {
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.Props.Name)
+	}
+	return names
+}
+
+func TestStructFieldsEmbedded(t *testing.T) {
+	for _, tt := range []struct {
+		value interface{}
+		want  []string
+	}{
+		{embeddedOuter{}, []string{"Created", "Own", "ID", "meta"}},
+		{struct {
+			embeddedBase
+			embeddedTagged
+		}{}, []string{"ID", "Name"}},
+		{struct {
+			embeddedBase
+			Base embeddedBase `msgp:"base"`
+		}{}, []string{"ID", "Name", "base"}},
+		{struct {
+			embeddedPtr
+		}{}, []string{"Deep", "Value"}},
+		{struct {
+			embeddedBase
+			EmbeddedAudit
+			embeddedTagged
+		}{}, []string{"ID", "Created", "Name"}},
+	} {
+		got := fieldNames(structFields(reflect.TypeOf(tt.value), defaultTagName, nil))
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%T: fields %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestEmbeddedRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	src := embeddedOuter{
+		embeddedBase:  embeddedBase{1, "base"},
+		EmbeddedAudit: &EmbeddedAudit{"audit", 2},
+		Own:           "own",
+		ID:            3,
+		Meta:          embeddedInner{4},
+	}
+	if err := Pack(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	var unknown interface{}
+	if err := Unpack(bytes.NewReader(buf.Bytes()), &unknown); err != nil {
+		t.Fatal(err)
+	}
+	want := "map[Created:2 ID:3 Own:own meta:map[Deep:4]]"
+	if got := fmt.Sprintf("%v", unknown); got != want {
+		t.Errorf("packed %s, want %s", got, want)
+	}
+
+	var dst embeddedOuter
+	if err := Unpack(&buf, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.EmbeddedAudit == nil {
+		t.Fatal("embedded pointer is not allocated")
+	}
+	if dst.Created != 2 || dst.Own != "own" || dst.ID != 3 || dst.Meta.Deep != 4 {
+		t.Errorf("unpacked %+v", dst)
+	}
+}
+
+func TestEmbeddedNilPointer(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Pack(&buf, embeddedPtr{Value: 1}); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x81, 0xa5, 'V', 'a', 'l', 'u', 'e', 0x01}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("packed % x, want % x", buf.Bytes(), want)
+	}
+}
+
+func TestEmbeddedUnexportedPointer(t *testing.T) {
+	type outer struct {
+		*embeddedInner
+	}
+
+	var buf bytes.Buffer
+	var dst outer
+
+	Pack(&buf, map[string]int{"Deep": 1})
+	if err := Unpack(&buf, &dst); err == nil {
+		t.Error("Unpack succeeded, want error")
+	}
+}
+
+func TestFieldPropsRequired(t *testing.T) {
+	type s struct {
+		A int `msgp:"a,required"`
+		B int `msgp:",omitempty,required"`
+		C int `msgp:"c"`
+	}
+
+	fields := structFields(reflect.TypeOf(s{}), "msgp", nil)
+	want := []bool{true, true, false}
+	for inx, f := range fields {
+		if f.Props.Required != want[inx] {
+			t.Errorf("%s: Required = %v, want %v", f.Name, f.Props.Required, want[inx])
+		}
+	}
+}
+
+func ExampleFieldProps_default() {
+	type config struct {
+		Host string `msgp:"host,default=localhost"`
+		Port int    `msgp:"port,omitempty,default=8080"`
+	}
+
+	var buf bytes.Buffer
+	Pack(&buf, map[string]interface{}{"host": "example.com"})
+
+	var c config
+	Unpack(&buf, &c)
+	fmt.Println(c.Host, c.Port)
+
+	enc := NewEncoder(&buf)
+	enc.UseDefaultAsEmpty(true)
+	enc.Encode(&c)
+	fmt.Printf("% x\n", buf.Bytes())
+
+	// Output:
+	// example.com 8080
+	// 81 a4 68 6f 73 74 ab 65 78 61 6d 70 6c 65 2e 63 6f 6d
+}
+
+func TestFieldPropsDefault(t *testing.T) {
+	type s struct {
+		A int     `msgp:"a,default=1"`
+		B string  `msgp:",default=x=y"`
+		C bool    `msgp:"c,required,default=true"`
+		D float32 `msgp:"d,omitempty"`
+	}
+
+	fields := structFields(reflect.TypeOf(s{}), "msgp", nil)
+	want := []struct {
+		value string
+		ok    bool
+	}{{"1", true}, {"x=y", true}, {"true", true}, {"", false}}
+	for inx, f := range fields {
+		if f.Props.Default != want[inx].value || f.Props.HasDefault != want[inx].ok {
+			t.Errorf("%s: Default = %q, %v", f.Name, f.Props.Default, f.Props.HasDefault)
+		}
+	}
+}
+
+func TestUnpackDefault(t *testing.T) {
+	type inner struct {
+		N uint8 `msgp:"n,default=7"`
+	}
+	type s struct {
+		I   int      `msgp:"i,default=-1"`
+		U   uint     `msgp:"u,default=2"`
+		F   float64  `msgp:"f,default=1.5"`
+		B   bool     `msgp:"b,default=true"`
+		S   string   `msgp:"s,default=text"`
+		P   *int     `msgp:"p,default=3"`
+		Nil *int     `msgp:"nil,default=nil"`
+		In  inner    `msgp:"in"`
+		Ins []inner  `msgp:"ins"`
+		Any []string `msgp:"any"`
+	}
+
+	// all keys are absent.
+	var v s
+	if err := Unpack(bytes.NewReader([]byte{0x80}), &v); err != nil {
+		t.Fatal(err)
+	}
+	three := 3
+	want := s{I: -1, U: 2, F: 1.5, B: true, S: "text", P: &three}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("got %+v, want %+v", v, want)
+	}
+
+	// the keys present are not replaced, even with zero values.
+	b := AppendMapHeader(nil, 4)
+	b = AppendInt(AppendString(b, "i"), 0)
+	b = AppendBool(AppendString(b, "b"), false)
+	b = AppendMapHeader(AppendString(b, "in"), 0)
+	b = AppendMapHeader(AppendArrayHeader(AppendString(b, "ins"), 1), 0)
+	v = s{}
+	if err := Unpack(bytes.NewReader(b), &v); err != nil {
+		t.Fatal(err)
+	}
+	want = s{I: 0, U: 2, F: 1.5, B: false, S: "text", P: &three, In: inner{N: 7}, Ins: []inner{{N: 7}}}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("got %+v, want %+v", v, want)
+	}
+
+	// a pointer default is not shared.
+	var v2 s
+	Unpack(bytes.NewReader([]byte{0x80}), &v2)
+	if v.P == v2.P {
+		t.Error("default pointer is shared")
+	}
+
+	// the elements absent from an array.
+	b = AppendArrayHeader(nil, 1)
+	b = AppendInt(b, 5)
+	v = s{}
+	if err := Unpack(bytes.NewReader(b), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.I != 5 || v.U != 2 || v.S != "text" {
+		t.Errorf("got %+v", v)
+	}
+
+	// a nil value sets the zero value without defaults.
+	v = s{I: 10}
+	if err := Unpack(bytes.NewReader([]byte{0xc0}), &v); err != nil || !reflect.DeepEqual(v, s{}) {
+		t.Errorf("got %+v, err %v", v, err)
+	}
+
+	// merge mode keeps the existing values.
+	d := NewDecoder(bytes.NewReader([]byte{0x80}))
+	d.UseMergeMode(true)
+	v = s{I: 10}
+	if err := d.Decode(&v); err != nil || v.I != 10 || v.S != "" {
+		t.Errorf("got %+v, err %v", v, err)
+	}
+}
+
+func TestDefaultErrors(t *testing.T) {
+	type badValue struct {
+		I int `msgp:"i,default=abc"`
+	}
+	type badType struct {
+		L []int `msgp:"l,default=1"`
+	}
+
+	var v1 badValue
+	err := Unpack(bytes.NewReader([]byte{0x80}), &v1)
+	if err == nil || !strings.Contains(err.Error(), `invalid default value "abc" for field msgp.badValue.I`) {
+		t.Errorf("got error %v", err)
+	}
+	var v2 badType
+	err = Unpack(bytes.NewReader([]byte{0x80}), &v2)
+	if err == nil || !strings.Contains(err.Error(), "default option is not supported for field msgp.badType.L") {
+		t.Errorf("got error %v", err)
+	}
+
+	// the key present is read as usual.
+	b := AppendInt(AppendString(AppendMapHeader(nil, 1), "i"), 1)
+	if err = Unpack(bytes.NewReader(b), &v1); err != nil || v1.I != 1 {
+		t.Errorf("got %v, err %v", v1, err)
+	}
+}
+
+func TestPackDefaultAsEmpty(t *testing.T) {
+	type s struct {
+		A int     `msgp:"a,omitempty,default=1"`
+		B float64 `msgp:"b,omitempty,default=0.5"`
+		C *string `msgp:"c,omitempty,default=x"`
+		D int     `msgp:"d,default=1"` // no omitempty
+	}
+	x, y := "x", "y"
+
+	tests := []struct {
+		value s
+		on    bool
+		keys  int
+	}{
+		{s{A: 1, B: 0.5, C: &x, D: 1}, false, 4},
+		{s{A: 1, B: 0.5, C: &x, D: 1}, true, 1},
+		{s{A: 2, B: 0.25, C: &y, D: 1}, true, 4},
+		{s{}, true, 1},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.UseDefaultAsEmpty(test.on)
+		if err := enc.Encode(&test.value); err != nil {
+			t.Fatal(err)
+		}
+		size, _, err := ReadMapHeaderBytes(buf.Bytes())
+		if err != nil || size != test.keys {
+			t.Errorf("%+v, %v: %d keys, err %v", test.value, test.on, size, err)
+		}
+
+		// omitted fields are restored by the default values.
+		var out s
+		if err = Unpack(&buf, &out); err != nil {
+			t.Fatal(err)
+		}
+		if test.on && test.value != (s{}) && !reflect.DeepEqual(out, test.value) {
+			t.Errorf("got %+v, want %+v", out, test.value)
+		}
+	}
+}
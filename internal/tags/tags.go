@@ -0,0 +1,59 @@
+// Package tags parses the struct field tags of msgp. It is shared by the msgp package
+// and the msgpgen command, so that both read the tags with the same grammar.
+package tags
+
+import (
+	"strings"
+)
+
+// Options is the string following a comma in a struct field's "msgp"
+// tag, or the empty string. It does not include the leading comma.
+type Options string
+
+// Parse splits a struct field's msgp tag into its name and
+// comma-separated options.
+func Parse(tag string) (string, Options) {
+	if idx := strings.Index(tag, ","); idx != -1 {
+		return strings.TrimSpace(tag[:idx]), Options(tag[idx+1:])
+	}
+	return strings.TrimSpace(tag), Options("")
+}
+
+// Contains reports whether a comma-separated list of options
+// contains a particular substr flag. substr must be surrounded by a
+// string boundary or commas.
+func (o Options) Contains(optionName string) bool {
+	if len(o) == 0 {
+		return false
+	}
+	s := strings.TrimSpace(string(o))
+	for s != "" {
+		var next string
+		i := strings.Index(s, ",")
+		if i >= 0 {
+			s, next = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+		}
+		if s == optionName {
+			return true
+		}
+		s = next
+	}
+	return false
+}
+
+// Value returns the value of an option in the form of "name=value".
+// The value cannot contain a comma.
+func (o Options) Value(optionName string) (string, bool) {
+	s := string(o)
+	for s != "" {
+		var next string
+		if i := strings.Index(s, ","); i >= 0 {
+			s, next = s[:i], s[i+1:]
+		}
+		if i := strings.Index(s, "="); i >= 0 && strings.TrimSpace(s[:i]) == optionName {
+			return strings.TrimSpace(s[i+1:]), true
+		}
+		s = next
+	}
+	return "", false
+}
@@ -0,0 +1,42 @@
+package tags
+
+import (
+	"testing"
+)
+
+func TestTagParsing(t *testing.T) {
+	name, opts := Parse("field, foobar, foo")
+	if name != "field" {
+		t.Fatalf("name = %q, want field", name)
+	}
+	for _, tt := range []struct {
+		opt  string
+		want bool
+	}{
+		{"foobar", true},
+		{"foo", true},
+		{"bar", false},
+	} {
+		if opts.Contains(tt.opt) != tt.want {
+			t.Errorf("Contains(%q) = %v", tt.opt, !tt.want)
+		}
+	}
+}
+
+func TestTagValue(t *testing.T) {
+	_, opts := Parse("field,omitempty, default = a b ,x=")
+	for _, tt := range []struct {
+		opt   string
+		value string
+		ok    bool
+	}{
+		{"default", "a b", true},
+		{"x", "", true},
+		{"omitempty", "", false},
+		{"y", "", false},
+	} {
+		if value, ok := opts.Value(tt.opt); value != tt.value || ok != tt.ok {
+			t.Errorf("Value(%q) = %q, %v", tt.opt, value, ok)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package msgp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ToJSON reads msgpack values from the io.Reader until io.EOF and writes each of them
+// to the io.Writer as a line of JSON text. The values are converted as follows:
+//
+//	nil                 null
+//	bool                true or false
+//	int, uint           an integer number
+//	float32, float64    a number with a decimal point or an exponent, e.g. 1.0
+//	str                 a string
+//	bin                 a base64 (standard encoding) string
+//	array               an array
+//	map                 an object. A key which is not a str is written as a string of its JSON
+//	                    text, e.g. "1", "true" or "null". Keys of array, map or ext format are errors.
+//	timestamp ext       an RFC 3339 string with nanoseconds in UTC
+//	other ext           an object {"type":<type code>,"data":<base64 string>}
+//
+// NaN and infinite floats cannot be represented in JSON and are errors.
+// If 'r' is a Decoder, its Limits are honored.
+// No interface{} tree is built; containers are converted element by element.
+func ToJSON(w io.Writer, r io.Reader) error {
+	var err error
+	var buf []byte
+
+	d := DecoderOf(r)
+	for {
+		if _, err = d.peekByte(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if buf, err = appendJSONValue(buf[:0], d); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		buf = append(buf, '\n')
+		if _, err = w.Write(buf); err != nil {
+			return err
+		}
+	}
+}
+
+// appendJSONValue reads a msgpack value from the Decoder and appends its JSON text to 'dst'.
+func appendJSONValue(dst []byte, d *Decoder) ([]byte, error) {
+	head, err := d.peekByte()
+	if err != nil {
+		return dst, err
+	}
+
+	switch {
+	case head&0xf0 == 0x90 || head == 0xdc || head == 0xdd: // array
+		size, err := d.ReadArrayHeader()
+		if err != nil {
+			return dst, err
+		}
+		if err = d.enter(size); err != nil {
+			return dst, err
+		}
+		defer d.leave()
+
+		dst = append(dst, '[')
+		for inx := 0; inx < size; inx++ {
+			if inx > 0 {
+				dst = append(dst, ',')
+			}
+			if dst, err = appendJSONValue(dst, d); err != nil {
+				return dst, err
+			}
+		}
+		return append(dst, ']'), nil
+
+	case head&0xf0 == 0x80 || head == 0xde || head == 0xdf: // map
+		size, err := d.ReadMapHeader()
+		if err != nil {
+			return dst, err
+		}
+		if err = d.enter(size); err != nil {
+			return dst, err
+		}
+		defer d.leave()
+
+		dst = append(dst, '{')
+		for inx := 0; inx < size; inx++ {
+			if inx > 0 {
+				dst = append(dst, ',')
+			}
+			if dst, err = appendJSONKey(dst, d); err != nil {
+				return dst, err
+			}
+			dst = append(dst, ':')
+			if dst, err = appendJSONValue(dst, d); err != nil {
+				return dst, err
+			}
+		}
+		return append(dst, '}'), nil
+
+	case isExtHead(head):
+		var ext Extension
+		if err = UnpackExtension(d, &ext); err != nil {
+			return dst, err
+		}
+		return appendJSONExt(dst, ext)
+	}
+
+	value, err := UnpackPrimitive(d)
+	if err != nil {
+		return dst, err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		dst = append(dst, "null"...)
+	case bool:
+		dst = strconv.AppendBool(dst, v)
+	case int8:
+		dst = strconv.AppendInt(dst, int64(v), 10)
+	case int16:
+		dst = strconv.AppendInt(dst, int64(v), 10)
+	case int32:
+		dst = strconv.AppendInt(dst, int64(v), 10)
+	case int64:
+		dst = strconv.AppendInt(dst, v, 10)
+	case uint8:
+		dst = strconv.AppendUint(dst, uint64(v), 10)
+	case uint16:
+		dst = strconv.AppendUint(dst, uint64(v), 10)
+	case uint32:
+		dst = strconv.AppendUint(dst, uint64(v), 10)
+	case uint64:
+		dst = strconv.AppendUint(dst, v, 10)
+	case float32:
+		return appendJSONFloat(dst, float64(v), 32)
+	case float64:
+		return appendJSONFloat(dst, v, 64)
+	case string:
+		dst = appendJSONString(dst, v)
+	case []byte:
+		dst = appendJSONBase64(dst, v)
+	default:
+		return dst, fmt.Errorf("msgp: unpacked value[format 0x%02x] cannot be converted to JSON", head)
+	}
+	return dst, nil
+}
+
+// appendJSONKey reads a map key from the Decoder and appends it to 'dst' as a JSON string.
+func appendJSONKey(dst []byte, d *Decoder) ([]byte, error) {
+	head, err := d.peekByte()
+	if err != nil {
+		return dst, err
+	}
+	if head&0xe0 == 0x80 || (head >= 0xdc && head <= 0xdf) || isExtHead(head) { // array, map or ext
+		return dst, fmt.Errorf("msgp: map key[format 0x%02x] cannot be converted to a JSON object key", head)
+	}
+
+	start := len(dst)
+	if dst, err = appendJSONValue(dst, d); err != nil {
+		return dst, err
+	}
+	if dst[start] != '"' { // not a string: quote the JSON text.
+		text := string(dst[start:])
+		dst = appendJSONString(dst[:start], text)
+	}
+	return dst, nil
+}
+
+// appendJSONExt appends an extension value to 'dst'.
+func appendJSONExt(dst []byte, ext Extension) ([]byte, error) {
+	if ext.Type == TimestampExtType {
+		var t time.Time
+		if err := decodeTimestamp(ext.Data, &t); err != nil {
+			return dst, err
+		}
+		dst = append(dst, '"')
+		dst = t.AppendFormat(dst, time.RFC3339Nano)
+		return append(dst, '"'), nil
+	}
+
+	dst = append(dst, `{"type":`...)
+	dst = strconv.AppendInt(dst, int64(ext.Type), 10)
+	dst = append(dst, `,"data":`...)
+	dst = appendJSONBase64(dst, ext.Data)
+	return append(dst, '}'), nil
+}
+
+// appendJSONFloat appends a float value so that it is read back as a float by FromJSON.
+func appendJSONFloat(dst []byte, f float64, bitSize int) ([]byte, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return dst, fmt.Errorf("msgp: float value %v cannot be converted to JSON", f)
+	}
+
+	start := len(dst)
+	dst = strconv.AppendFloat(dst, f, 'g', -1, bitSize)
+	for _, c := range dst[start:] {
+		if c == '.' || c == 'e' {
+			return dst, nil
+		}
+	}
+	return append(dst, ".0"...), nil
+}
+
+func appendJSONBase64(dst []byte, data []byte) []byte {
+	dst = append(dst, '"')
+	start := len(dst)
+	n := base64.StdEncoding.EncodedLen(len(data))
+	dst = append(dst, make([]byte, n)...)
+	base64.StdEncoding.Encode(dst[start:], data)
+	return append(dst, '"')
+}
+
+// appendJSONString appends a quoted JSON string. Invalid UTF-8 is replaced with U+FFFD.
+func appendJSONString(dst []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+
+	dst = append(dst, '"')
+	for inx := 0; inx < len(s); {
+		c := s[inx]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				dst = append(dst, '\\', c)
+			case c == '\n':
+				dst = append(dst, '\\', 'n')
+			case c == '\r':
+				dst = append(dst, '\\', 'r')
+			case c == '\t':
+				dst = append(dst, '\\', 't')
+			case c < 0x20:
+				dst = append(dst, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			default:
+				dst = append(dst, c)
+			}
+			inx++
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[inx:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, "\ufffd"...)
+		} else {
+			dst = append(dst, s[inx:inx+size]...)
+		}
+		inx += size
+	}
+	return append(dst, '"')
+}
+
+// FromJSON reads JSON values from the io.Reader until io.EOF and writes each of them
+// to the io.Writer as a msgpack value. The values are converted as follows:
+//
+//	null                nil
+//	true, false         bool
+//	number              an int, or a uint if it doesn't fit in int64, when it has no decimal
+//	                    point and no exponent. Otherwise, a float64.
+//	string              str
+//	array               array
+//	object              map with str keys, in the order of the JSON text
+//
+// The bin, ext and float32 values written by ToJSON are not restored; they are read back
+// as str, map or str, and float64 values. No interface{} tree is built.
+func FromJSON(w io.Writer, r io.Reader) error {
+	var err error
+	var buf []byte
+
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	for {
+		if buf, err = appendJSONToMsgp(buf[:0], dec, true); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err = w.Write(buf); err != nil {
+			return err
+		}
+	}
+}
+
+// appendJSONToMsgp reads a JSON value from the json.Decoder and appends it to 'dst'
+// as a msgpack value. The elements of an array or an object are appended to a separate
+// buffer first, because the header needs the number of them.
+func appendJSONToMsgp(dst []byte, dec *json.Decoder, top bool) ([]byte, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF && !top {
+			err = io.ErrUnexpectedEOF
+		}
+		return dst, err
+	}
+
+	switch t := tok.(type) {
+	case nil:
+		dst = AppendNil(dst)
+	case bool:
+		dst = AppendBool(dst, t)
+	case string:
+		dst = AppendString(dst, t)
+	case json.Number:
+		return appendJSONNumber(dst, t)
+	case json.Delim:
+		var elems []byte
+		size := 0
+		for dec.More() {
+			if t == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return dst, err
+				}
+				elems = AppendString(elems, key.(string))
+			}
+			if elems, err = appendJSONToMsgp(elems, dec, false); err != nil {
+				return dst, err
+			}
+			size++
+		}
+		if _, err = dec.Token(); err != nil { // closing delimiter
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return dst, err
+		}
+		if t == '{' {
+			dst = AppendMapHeader(dst, size)
+		} else {
+			dst = AppendArrayHeader(dst, size)
+		}
+		dst = append(dst, elems...)
+	default:
+		return dst, errors.New("msgp: unexpected JSON token")
+	}
+	return dst, nil
+}
+
+func appendJSONNumber(dst []byte, num json.Number) ([]byte, error) {
+	s := string(num)
+	if !strings.ContainsAny(s, ".eE") {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return AppendInt(dst, i), nil
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return AppendUint(dst, u), nil
+		}
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return dst, fmt.Errorf("msgp: invalid JSON number %q", s)
+	}
+	return AppendFloat64(dst, f), nil
+}
@@ -0,0 +1,129 @@
+package msgp
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ExampleToJSON() {
+	var buf bytes.Buffer
+	Pack(&buf, struct {
+		ID    int     `msgp:"id"`
+		Price float64 `msgp:"price"`
+	}{7, 10})
+	Pack(&buf, []byte("hi"))
+
+	ToJSON(os.Stdout, &buf)
+
+	// Output:
+	// {"id":7,"price":10.0}
+	// "aGk="
+}
+
+func ExampleFromJSON() {
+	var buf bytes.Buffer
+	FromJSON(&buf, strings.NewReader(`[1, 1.0, "a"]`))
+
+	fmt.Printf("% x\n", buf.Bytes())
+
+	// Output:
+	// 93 01 cb 3f f0 00 00 00 00 00 00 a1 61
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	inputs := []string{
+		`null`,
+		`true`,
+		`[0,-1,127,128,-129,65536,9223372036854775807,-9223372036854775808,18446744073709551615]`,
+		`[1.0,-0.5,1e+21,1.5e-07,3.141592653589793]`,
+		`"tab\tquote\"backslash\\ctrl\u0001 한글"`,
+		`{"b":[],"a":{},"c":[{"x":null}]}`,
+	}
+	for _, input := range inputs {
+		var packed, out bytes.Buffer
+		if err := FromJSON(&packed, strings.NewReader(input)); err != nil {
+			t.Fatalf("FromJSON(%s): %v", input, err)
+		}
+		if err := ToJSON(&out, &packed); err != nil {
+			t.Fatalf("ToJSON(%s): %v", input, err)
+		}
+		if got := strings.TrimSuffix(out.String(), "\n"); got != input {
+			t.Errorf("round trip: got %s, want %s", got, input)
+		}
+	}
+}
+
+func TestFromJSONNumbers(t *testing.T) {
+	var buf bytes.Buffer
+	if err := FromJSON(&buf, strings.NewReader(`1 1.0 1e2 18446744073709551615 -1`)); err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{int8(1), float64(1), float64(100), uint64(math.MaxUint64), int8(-1)}
+	for _, w := range want {
+		v, err := UnpackPrimitive(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != w {
+			t.Errorf("got %T(%v), want %T(%v)", v, v, w, w)
+		}
+	}
+}
+
+func TestToJSONConversions(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 600, time.UTC)
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{float32(2), `2.0`},
+		{map[int]string{1: "a"}, `{"1":"a"}`},
+		{map[bool]int{true: 1}, `{"true":1}`},
+		{map[float64]int{0.5: 1}, `{"0.5":1}`},
+		{ts, `"2020-01-02T03:04:05.0000006Z"`},
+		{Extension{Type: 5, Data: []byte{1, 2, 3}}, `{"type":5,"data":"AQID"}`},
+		{"\xff", `"�"`},
+	}
+	for _, test := range tests {
+		var buf, out bytes.Buffer
+		if err := Pack(&buf, test.value); err != nil {
+			t.Fatal(err)
+		}
+		if err := ToJSON(&out, &buf); err != nil {
+			t.Fatalf("ToJSON(%v): %v", test.value, err)
+		}
+		if got := strings.TrimSuffix(out.String(), "\n"); got != test.want {
+			t.Errorf("ToJSON(%v): got %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestToJSONErrors(t *testing.T) {
+	values := []interface{}{
+		math.NaN(),
+		math.Inf(1),
+		map[interface{}]int{[2]int{1, 2}: 1},
+	}
+	for _, value := range values {
+		var buf bytes.Buffer
+		if err := Pack(&buf, value); err != nil {
+			t.Fatal(err)
+		}
+		if err := ToJSON(&bytes.Buffer{}, &buf); err == nil {
+			t.Errorf("ToJSON(%v): expected an error", value)
+		}
+	}
+
+	// truncated array
+	if err := ToJSON(&bytes.Buffer{}, bytes.NewReader([]byte{0x92, 0x01})); err == nil {
+		t.Error("ToJSON of a truncated array: expected an error")
+	}
+	if err := FromJSON(&bytes.Buffer{}, strings.NewReader(`[1,2`)); err == nil {
+		t.Error("FromJSON of a truncated array: expected an error")
+	}
+}
@@ -0,0 +1,133 @@
+package msgp
+
+import (
+	"fmt"
+)
+
+// Limits restricts the resources used by a Decoder to decode values from untrusted input.
+// A zero field means the default limit, and a negative field means no limit.
+// The default limits are:
+//
+//	MaxDepth         10000
+//	MaxContainerLen  16777216 (1 << 24)
+//	MaxStringLen     67108864 (64 MiB)
+//	MaxBytes         268435456 (256 MiB)
+//
+// Independent of the limits, the memory for a value is allocated as its data arrives,
+// so a length in a header never allocates more than a small amount of memory by itself.
+type Limits struct {
+	// MaxDepth is the maximum nesting depth of arrays and maps, including structs.
+	MaxDepth int
+	// MaxContainerLen is the maximum number of elements of an array, or key-value pairs of a map.
+	MaxContainerLen int
+	// MaxStringLen is the maximum length of the data of a str, bin or ext value.
+	MaxStringLen int
+	// MaxBytes is the maximum number of bytes of the elements of a top-level array or map,
+	// including structs. It is counted for each top-level value.
+	MaxBytes int
+}
+
+const (
+	defaultMaxDepth        = 10000
+	defaultMaxContainerLen = 1 << 24
+	defaultMaxStringLen    = 64 << 20
+	defaultMaxBytes        = 256 << 20
+
+	maxPreallocLen = 1 << 12 // maximum number of elements allocated before they are read.
+)
+
+// LimitError is returned when a value exceeds one of the Limits of a Decoder.
+type LimitError struct {
+	Limit string // name of the field of Limits, e.g. "MaxDepth"
+	Max   int    // the limit
+	Size  int    // the depth, length or number of bytes that exceeds the limit
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("msgp: %s exceeded: %d > %d", e.Limit, e.Size, e.Max)
+}
+
+// limit returns the effective value of a field of Limits, or -1 for no limit.
+func limit(value, def int) int {
+	if value == 0 {
+		return def
+	} else if value < 0 {
+		return -1
+	}
+	return value
+}
+
+func checkLimit(name string, size, value, def int) error {
+	if max := limit(value, def); max >= 0 && size > max {
+		return &LimitError{Limit: name, Max: max, Size: size}
+	}
+	return nil
+}
+
+// checkContainerLen checks the number of elements of an array or key-value pairs of a map.
+func (d *Decoder) checkContainerLen(size int) error {
+	if size < 0 { // overflow of int on 32-bit platforms
+		size = int(^uint(0) >> 1)
+	}
+	return checkLimit("MaxContainerLen", size, d.limits.MaxContainerLen, defaultMaxContainerLen)
+}
+
+// checkStringLen checks the length of the data of a str, bin or ext value.
+func (d *Decoder) checkStringLen(len int) error {
+	if len < 0 { // overflow of int on 32-bit platforms
+		len = int(^uint(0) >> 1)
+	}
+	return checkLimit("MaxStringLen", len, d.limits.MaxStringLen, defaultMaxStringLen)
+}
+
+// checkBytes checks the number of bytes of the current top-level value if the next n bytes are read.
+func (d *Decoder) checkBytes(n int) error {
+	if d.depth == 0 {
+		return nil
+	}
+	size := int(d.read - int64(d.w-d.r) - d.start + int64(n))
+	return checkLimit("MaxBytes", size, d.limits.MaxBytes, defaultMaxBytes)
+}
+
+// enter is called after the header of an array or a map with 'size' elements or pairs is read.
+// It checks the limits and increases the depth. leave must be called after the elements are read.
+func (d *Decoder) enter(size int) error {
+	if err := d.checkContainerLen(size); err != nil {
+		return err
+	}
+	if err := checkLimit("MaxDepth", d.depth+1, d.limits.MaxDepth, defaultMaxDepth); err != nil {
+		return err
+	}
+	if d.depth == 0 {
+		d.start = d.read - int64(d.w-d.r)
+	}
+	d.depth++
+	return nil
+}
+
+func (d *Decoder) leave() {
+	d.depth--
+}
+
+// Enter increases the nesting depth of the Decoder after the header of an array or a map is
+// read with the Read methods, and checks MaxDepth. From the outermost Enter to the matching Leave,
+// the bytes read are counted for MaxBytes. Leave must be called after the elements are read.
+// The methods reading struct values by themselves, such as DecodeMsgpack methods and the
+// UnmarshalMsg methods generated by msgpgen, call them to be bounded by the Limits.
+func (d *Decoder) Enter() error {
+	return d.enter(0)
+}
+
+// Leave decreases the nesting depth increased by Enter.
+func (d *Decoder) Leave() {
+	d.leave()
+}
+
+// preallocLen returns the number of elements to allocate for an array of 'size' elements
+// before the elements are read.
+func preallocLen(size int) int {
+	if size > maxPreallocLen {
+		return maxPreallocLen
+	}
+	return size
+}
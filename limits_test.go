@@ -0,0 +1,233 @@
+package msgp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func ExampleDecoder_SetLimits() {
+	var buf bytes.Buffer
+	Pack(&buf, []int{1, 2, 3, 4})
+
+	d := NewDecoder(&buf)
+	d.SetLimits(Limits{MaxContainerLen: 3})
+
+	var v []int
+	err := d.Decode(&v)
+
+	var le *LimitError
+	fmt.Println(errors.As(err, &le), le.Limit)
+	fmt.Println(err)
+
+	// Output:
+	// true MaxContainerLen
+	// msgp: MaxContainerLen exceeded: 4 > 3
+}
+
+func limitErrorOf(err error) string {
+	var le *LimitError
+	if errors.As(err, &le) {
+		return le.Limit
+	}
+	return ""
+}
+
+func TestDefaultLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		ptr   interface{}
+		limit string
+	}{
+		{"bin32", []byte{0xc6, 0xff, 0xff, 0xff, 0xff}, new([]byte), "MaxStringLen"},
+		{"str32", []byte{0xdb, 0xff, 0xff, 0xff, 0xff}, new(string), "MaxStringLen"},
+		{"ext32", []byte{0xc9, 0xff, 0xff, 0xff, 0xff, 0x01}, new(Extension), "MaxStringLen"},
+		{"array32 to slice", []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, new([]int), "MaxContainerLen"},
+		{"array32 to interface", []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, new(interface{}), "MaxContainerLen"},
+		{"map32", []byte{0xdf, 0xff, 0xff, 0xff, 0xff}, new(map[string]int), "MaxContainerLen"},
+		{"map32 to struct", []byte{0xdf, 0xff, 0xff, 0xff, 0xff}, new(struct{ A int }), "MaxContainerLen"},
+		{"raw", []byte{0xdd, 0xff, 0xff, 0xff, 0xff}, new(Raw), "MaxContainerLen"},
+		{"depth", bytes.Repeat([]byte{0x91}, defaultMaxDepth+1), new(interface{}), "MaxDepth"},
+	}
+	for _, test := range tests {
+		err := Unpack(bytes.NewReader(test.data), test.ptr)
+		if got := limitErrorOf(err); got != test.limit {
+			t.Errorf("%s: got error %v, want a LimitError of %s", test.name, err, test.limit)
+		}
+	}
+
+	err := Skip(bytes.NewReader([]byte{0xc6, 0xff, 0xff, 0xff, 0xff}))
+	if got := limitErrorOf(err); got != "MaxStringLen" {
+		t.Errorf("Skip: got error %v, want a LimitError of MaxStringLen", err)
+	}
+	_, err = NewDecoder(bytes.NewReader([]byte{0xdd, 0xff, 0xff, 0xff, 0xff})).ReadArrayHeader()
+	if got := limitErrorOf(err); got != "MaxContainerLen" {
+		t.Errorf("ReadArrayHeader: got error %v, want a LimitError of MaxContainerLen", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	var buf bytes.Buffer
+	Pack(&buf, []interface{}{[]string{"abcd", "efgh"}, "ijkl"})
+	data := buf.Bytes()
+
+	tests := []struct {
+		limits Limits
+		limit  string
+	}{
+		{Limits{}, ""},
+		{Limits{MaxDepth: 1}, "MaxDepth"},
+		{Limits{MaxDepth: 2}, ""},
+		{Limits{MaxContainerLen: 1}, "MaxContainerLen"},
+		{Limits{MaxStringLen: 3}, "MaxStringLen"},
+		{Limits{MaxStringLen: 4}, ""},
+		{Limits{MaxBytes: 10}, "MaxBytes"},
+		{Limits{MaxBytes: len(data)}, ""},
+		{Limits{MaxDepth: -1, MaxContainerLen: -1, MaxStringLen: -1, MaxBytes: -1}, ""},
+	}
+	for _, test := range tests {
+		var v interface{}
+		d := NewDecoder(bytes.NewReader(data))
+		d.SetLimits(test.limits)
+		err := d.Decode(&v)
+		if got := limitErrorOf(err); got != test.limit {
+			t.Errorf("%+v: got error %v, want a LimitError of %q", test.limits, err, test.limit)
+		}
+		if test.limit == "" && err != nil {
+			t.Errorf("%+v: unexpected error %v", test.limits, err)
+		}
+	}
+}
+
+func TestLimitsBytesPerValue(t *testing.T) {
+	var buf bytes.Buffer
+	for inx := 0; inx < 100; inx++ {
+		Pack(&buf, []string{"abcdefgh"})
+	}
+
+	d := NewDecoder(&buf)
+	d.SetLimits(Limits{MaxBytes: 16})
+	for d.More() {
+		var v []string
+		if err := d.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// limitNode reads itself with the Read methods, bounded by Enter and Leave.
+type limitNode struct {
+	Next *limitNode
+}
+
+func (n *limitNode) DecodeMsgpack(d *Decoder) error {
+	size, err := d.ReadArrayHeader()
+	if err != nil {
+		return err
+	}
+	if err = d.Enter(); err != nil {
+		return err
+	}
+	defer d.Leave()
+
+	for inx := 0; inx < size; inx++ {
+		if isNil, err := d.ReadNil(); err != nil {
+			return err
+		} else if !isNil {
+			n.Next = &limitNode{}
+			if err = n.Next.DecodeMsgpack(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestLimitsEnterLeave(t *testing.T) {
+	deep := func(depth int) []byte {
+		var b []byte
+		for inx := 0; inx < depth; inx++ {
+			b = AppendArrayHeader(b, 1)
+		}
+		return AppendNil(b)
+	}
+
+	var n limitNode
+	if err := Unpack(bytes.NewReader(deep(10000)), &n); err != nil {
+		t.Fatal(err)
+	}
+	var le *LimitError
+	if err := Unpack(bytes.NewReader(deep(20000)), &n); !errors.As(err, &le) || le.Limit != "MaxDepth" {
+		t.Errorf("got error %v", err)
+	}
+
+	// the depth is restored by Leave.
+	d := NewDecoder(bytes.NewReader(append(deep(3), deep(3)...)))
+	d.SetLimits(Limits{MaxDepth: 3})
+	for inx := 0; inx < 2; inx++ {
+		if err := d.Decode(&n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the bytes are counted from the outermost Enter.
+	b := AppendArrayHeader(nil, 1)
+	b = AppendArrayHeader(b, 1)
+	d = NewDecoder(bytes.NewReader(append(b, 0xc0)))
+	d.SetLimits(Limits{MaxBytes: 1})
+	if err := d.Decode(&n); !errors.As(err, &le) || le.Limit != "MaxBytes" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestHostileLengthDoesNotAllocate(t *testing.T) {
+	tests := []struct {
+		data []byte
+		ptr  interface{}
+	}{
+		{[]byte{0xc6, 0xff, 0xff, 0xff, 0xff}, new([]byte)},
+		{[]byte{0xdd, 0xff, 0xff, 0xff, 0xff}, new([]int64)},
+		{[]byte{0xdd, 0xff, 0xff, 0xff, 0xff}, new(interface{})},
+	}
+	for _, test := range tests {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+
+		d := NewDecoder(bytes.NewReader(test.data))
+		d.SetLimits(Limits{MaxDepth: -1, MaxContainerLen: -1, MaxStringLen: -1, MaxBytes: -1})
+		err := d.Decode(test.ptr)
+		if err == nil || limitErrorOf(err) != "" {
+			t.Errorf("% x: got error %v, want an EOF error", test.data, err)
+		}
+
+		runtime.ReadMemStats(&after)
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+			t.Errorf("% x: allocated %d bytes", test.data, alloc)
+		}
+	}
+}
+
+func TestLimitsLargeValues(t *testing.T) {
+	s := strings.Repeat("x", 100000)
+	ints := make([]int, 10000)
+	for inx := range ints {
+		ints[inx] = inx
+	}
+
+	var buf bytes.Buffer
+	Pack(&buf, s)
+	Pack(&buf, ints)
+
+	var s2 string
+	var ints2 []int
+	if err := Unpack(&buf, &s2); err != nil || s2 != s {
+		t.Fatalf("string: %v", err)
+	}
+	if err := Unpack(&buf, &ints2); err != nil || len(ints2) != len(ints) || ints2[9999] != 9999 {
+		t.Fatalf("slice: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package msgp
+
+import (
+	"encoding"
+	"reflect"
+)
+
+// Marshaler is the interface implemented by types that can marshal themselves into
+// a valid msgpack value. The returned bytes are written as they are.
+type Marshaler interface {
+	MarshalMsgpack() ([]byte, error)
+}
+
+// Unmarshaler is the interface implemented by types that can unmarshal a msgpack value
+// of themselves. UnmarshalMsgpack receives the encoded bytes of exactly one value.
+// It must copy the data if it wishes to retain the data after returning.
+type Unmarshaler interface {
+	UnmarshalMsgpack([]byte) error
+}
+
+// StreamMarshaler is the interface implemented by types that can write themselves to an Encoder.
+// EncodeMsgpack must write exactly one value.
+type StreamMarshaler interface {
+	EncodeMsgpack(enc *Encoder) error
+}
+
+// StreamUnmarshaler is the interface implemented by types that can read themselves from a Decoder.
+// DecodeMsgpack must read exactly one value.
+type StreamUnmarshaler interface {
+	DecodeMsgpack(dec *Decoder) error
+}
+
+var (
+	marshalerType       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	streamMarshalerType = reflect.TypeOf((*StreamMarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+)
+
+func isMarshaler(typ reflect.Type) bool {
+	return typ.Implements(streamMarshalerType) || typ.Implements(marshalerType)
+}
+
+func isEncodingMarshaler(typ reflect.Type) bool {
+	return typ.Implements(binaryMarshalerType) || typ.Implements(textMarshalerType)
+}
+
+// marshalerOf returns the value whose methods satisfy 'implements'.
+// If 'v' is addressable, the methods with pointer receiver are also considered.
+func marshalerOf(v reflect.Value, implements func(reflect.Type) bool) (interface{}, bool) {
+	if v.Kind() != reflect.Ptr && v.CanAddr() && implements(v.Addr().Type()) {
+		v = v.Addr()
+	} else if !implements(v.Type()) {
+		return nil, false
+	}
+	if !v.CanInterface() {
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
+// packMarshaler writes 'v' with its StreamMarshaler or Marshaler method.
+// It returns false if 'v' doesn't implement either of them.
+func packMarshaler(e *Encoder, v reflect.Value) (bool, error) {
+	m, ok := marshalerOf(v, isMarshaler)
+	if !ok {
+		return false, nil
+	}
+
+	switch m := m.(type) {
+	case StreamMarshaler:
+		return true, m.EncodeMsgpack(e)
+	case Marshaler:
+		data, err := m.MarshalMsgpack()
+		if err != nil {
+			return true, err
+		}
+		_, err = e.Write(data)
+		return true, err
+	}
+	return false, nil
+}
+
+// unpackUnmarshaler reads a value with the StreamUnmarshaler or Unmarshaler method of 'ptr'.
+// It returns false if 'ptr' doesn't implement either of them.
+func unpackUnmarshaler(d *Decoder, ptr interface{}) (bool, error) {
+	switch u := ptr.(type) {
+	case StreamUnmarshaler:
+		return true, u.DecodeMsgpack(d)
+	case Unmarshaler:
+		data, err := appendRawValue(d, nil)
+		if err != nil {
+			return true, err
+		}
+		return true, u.UnmarshalMsgpack(data)
+	}
+	return false, nil
+}
+
+// packEncodingMarshaler writes 'v' as bin with its encoding.BinaryMarshaler method,
+// or as str with its encoding.TextMarshaler method.
+// It returns false if 'v' doesn't implement either of them.
+func packEncodingMarshaler(e *Encoder, v reflect.Value) (bool, error) {
+	m, ok := marshalerOf(v, isEncodingMarshaler)
+	if !ok {
+		return false, nil
+	}
+
+	switch m := m.(type) {
+	case encoding.BinaryMarshaler:
+		data, err := m.MarshalBinary()
+		if err != nil {
+			return true, err
+		}
+		return true, PackArray(e, data)
+	case encoding.TextMarshaler:
+		text, err := m.MarshalText()
+		if err != nil {
+			return true, err
+		}
+		return true, PackString(e, string(text))
+	}
+	return false, nil
+}
+
+// unpackEncodingUnmarshaler reads a str or bin value with the encoding.TextUnmarshaler or
+// encoding.BinaryUnmarshaler method of 'ptr'. A str value is passed to UnmarshalText and a bin value
+// to UnmarshalBinary in preference, but either method is used if the other is not implemented.
+// It returns false if 'ptr' doesn't implement either of them.
+func unpackEncodingUnmarshaler(d *Decoder, ptr interface{}) (bool, error) {
+	tu, isText := ptr.(encoding.TextUnmarshaler)
+	bu, isBinary := ptr.(encoding.BinaryUnmarshaler)
+	if !isText && !isBinary {
+		return false, nil
+	}
+
+	head, err := d.peekByte()
+	if err != nil {
+		return true, err
+	}
+	offset := d.offset()
+
+	val, err := UnpackPrimitive(d)
+	if err != nil {
+		return true, err
+	}
+
+	switch val := val.(type) {
+	case nil:
+		reflect.ValueOf(ptr).Elem().Set(reflect.Zero(reflect.TypeOf(ptr).Elem()))
+		return true, nil
+	case string:
+		if isText {
+			return true, tu.UnmarshalText([]byte(val))
+		}
+		return true, bu.UnmarshalBinary([]byte(val))
+	case []byte:
+		if isBinary {
+			return true, bu.UnmarshalBinary(val)
+		}
+		return true, tu.UnmarshalText(val)
+	}
+	return true, typeError(head, offset, reflect.TypeOf(ptr).Elem(), "")
+}
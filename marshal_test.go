@@ -0,0 +1,260 @@
+package msgp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+)
+
+// upper packs itself as an upper case string.
+type upper string
+
+func (u upper) MarshalMsgpack() ([]byte, error) {
+	var buf bytes.Buffer
+	err := PackString(&buf, strings.ToUpper(string(u)))
+	return buf.Bytes(), err
+}
+
+func (u *upper) UnmarshalMsgpack(data []byte) error {
+	var str string
+	if err := Unpack(bytes.NewReader(data), &str); err != nil {
+		return err
+	}
+	*u = upper(strings.ToLower(str))
+	return nil
+}
+
+// pair packs itself as a 2-element array with pointer receiver methods.
+type pair struct {
+	A int
+	B string
+}
+
+func (p *pair) EncodeMsgpack(enc *Encoder) error {
+	return enc.Encode([]interface{}{p.A, p.B})
+}
+
+func (p *pair) DecodeMsgpack(dec *Decoder) error {
+	var a []interface{}
+	if err := dec.Decode(&a); err != nil {
+		return err
+	}
+	if len(a) != 2 {
+		return errors.New("invalid pair")
+	}
+	p.A = int(a[0].(int8))
+	p.B = a[1].(string)
+	return nil
+}
+
+func ExampleMarshaler() {
+	var buf bytes.Buffer
+	var u upper
+
+	Pack(&buf, upper("abc"))
+	fmt.Printf("% x\n", buf.Bytes())
+
+	Unpack(&buf, &u)
+	fmt.Printf("%v\n", u)
+
+	// Output:
+	// a3 41 42 43
+	// abc
+}
+
+func TestMarshalerInContainers(t *testing.T) {
+	type holder struct {
+		U  upper
+		UP *upper
+		US []upper
+		UM map[upper]upper
+	}
+
+	var buf bytes.Buffer
+	up := upper("b")
+	src := holder{"a", &up, []upper{"c", "d"}, map[upper]upper{"e": "f"}}
+	if err := Pack(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	var unknown interface{}
+	if err := Unpack(bytes.NewReader(buf.Bytes()), &unknown); err != nil {
+		t.Fatal(err)
+	}
+	want := "map[U:A UM:map[E:F] UP:B US:[C D]]"
+	if got := fmt.Sprintf("%v", unknown); got != want {
+		t.Errorf("packed %s, want %s", got, want)
+	}
+
+	var dst holder
+	if err := Unpack(&buf, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%v %v %v %v", dst.U, *dst.UP, dst.US, dst.UM) != "a b [c d] map[e:f]" {
+		t.Errorf("unpacked %v %v %v %v", dst.U, *dst.UP, dst.US, dst.UM)
+	}
+}
+
+func TestStreamMarshalerPointerReceiver(t *testing.T) {
+	type holder struct {
+		P  pair
+		PS []pair
+	}
+
+	var buf bytes.Buffer
+	src := holder{pair{1, "a"}, []pair{{2, "b"}}}
+
+	// fields are addressable through a pointer.
+	if err := Pack(&buf, &src); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x82, 0xa1, 'P', 0x92, 0x01, 0xa1, 'a', 0xa2, 'P', 'S', 0x91, 0x92, 0x02, 0xa1, 'b'}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("packed % x, want % x", buf.Bytes(), want)
+	}
+
+	var dst holder
+	if err := Unpack(&buf, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.P != src.P || len(dst.PS) != 1 || dst.PS[0] != src.PS[0] {
+		t.Errorf("unpacked %+v, want %+v", dst, src)
+	}
+}
+
+func TestMarshalerError(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Pack(&buf, []failing{{}}); err == nil || err.Error() != "failing" {
+		t.Errorf("err = %v, want failing", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("packed % x, want nothing", buf.Bytes())
+	}
+}
+
+type failing struct{}
+
+func (failing) MarshalMsgpack() ([]byte, error) {
+	return nil, errors.New("failing")
+}
+
+// color implements encoding.BinaryMarshaler and encoding.BinaryUnmarshaler.
+type color struct {
+	R, G, B uint8
+}
+
+func (c color) MarshalBinary() ([]byte, error) {
+	return []byte{c.R, c.G, c.B}, nil
+}
+
+func (c *color) UnmarshalBinary(data []byte) error {
+	if len(data) != 3 {
+		return errors.New("invalid color")
+	}
+	c.R, c.G, c.B = data[0], data[1], data[2]
+	return nil
+}
+
+func ExamplePack_textMarshaler() {
+	var buf bytes.Buffer
+
+	Pack(&buf, net.IPv4(127, 0, 0, 1))
+	fmt.Printf("% x\n", buf.Bytes())
+
+	buf.Reset()
+
+	Pack(&buf, color{1, 2, 3})
+	fmt.Printf("% x\n", buf.Bytes())
+
+	// Output:
+	// a9 31 32 37 2e 30 2e 30 2e 31
+	// c4 03 01 02 03
+}
+
+func TestEncodingMarshalers(t *testing.T) {
+	type holder struct {
+		IP    net.IP
+		Big   *big.Int
+		BigV  big.Int
+		Color color
+		Dir   direction
+	}
+
+	var buf bytes.Buffer
+	src := holder{net.ParseIP("10.0.0.1"), big.NewInt(0).Lsh(big.NewInt(1), 100), *big.NewInt(-5), color{4, 5, 6}, west}
+	if err := Pack(&buf, &src); err != nil {
+		t.Fatal(err)
+	}
+
+	var unknown interface{}
+	if err := Unpack(bytes.NewReader(buf.Bytes()), &unknown); err != nil {
+		t.Fatal(err)
+	}
+	want := "map[Big:1267650600228229401496703205376 BigV:-5 Color:[4 5 6] Dir:west IP:10.0.0.1]"
+	if got := fmt.Sprintf("%v", unknown); got != want {
+		t.Errorf("packed %s, want %s", got, want)
+	}
+
+	var dst holder
+	if err := Unpack(&buf, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if !dst.IP.Equal(src.IP) || dst.Big.Cmp(src.Big) != 0 || dst.BigV.Cmp(&src.BigV) != 0 || dst.Color != src.Color || dst.Dir != src.Dir {
+		t.Errorf("unpacked %+v, want %+v", dst, src)
+	}
+}
+
+// direction implements only encoding.TextMarshaler and encoding.TextUnmarshaler.
+type direction int
+
+const (
+	east direction = iota
+	west
+)
+
+func (d direction) MarshalText() ([]byte, error) {
+	if d == west {
+		return []byte("west"), nil
+	}
+	return []byte("east"), nil
+}
+
+func (d *direction) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "east":
+		*d = east
+	case "west":
+		*d = west
+	default:
+		return fmt.Errorf("invalid direction %q", text)
+	}
+	return nil
+}
+
+func TestEncodingMarshalersDisabled(t *testing.T) {
+	var buf bytes.Buffer
+
+	enc := NewEncoder(&buf)
+	enc.UseEncodingMarshalers(false)
+	if err := enc.Encode([]direction{west}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x91, 0x01}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("packed % x, want % x", buf.Bytes(), want)
+	}
+
+	var dirs []direction
+	dec := NewDecoder(&buf)
+	dec.UseEncodingMarshalers(false)
+	if err := dec.Decode(&dirs); err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 || dirs[0] != west {
+		t.Errorf("unpacked %v, want [west]", dirs)
+	}
+}
@@ -0,0 +1,93 @@
+package msgp
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NamingStrategy converts the Go name of a struct field to the key of the field in a map.
+// It is applied to the fields without a name in the tag; a name in the tag always wins.
+// The predefined strategies convert "UserID" and "HTTPServer" as follows:
+//
+//	SnakeCase  user_id   http_server
+//	CamelCase  userID    httpServer
+//	KebabCase  user-id   http-server
+//	LowerCase  userid    httpserver
+//
+// A NamingStrategy is identified by its pointer, so create a custom strategy once and reuse it.
+type NamingStrategy struct {
+	convert func(goName string) string
+}
+
+// NewNamingStrategy returns a NamingStrategy which converts the Go names with 'convert'.
+// 'convert' must return the same key for the same name.
+func NewNamingStrategy(convert func(goName string) string) *NamingStrategy {
+	return &NamingStrategy{convert}
+}
+
+// FieldName returns the key of a field with the Go name.
+func (s *NamingStrategy) FieldName(goName string) string {
+	return s.convert(goName)
+}
+
+// The predefined naming strategies.
+var (
+	SnakeCase = NewNamingStrategy(func(name string) string { return joinWords(splitWords(name), "_") })
+	CamelCase = NewNamingStrategy(toCamelCase)
+	KebabCase = NewNamingStrategy(func(name string) string { return joinWords(splitWords(name), "-") })
+	LowerCase = NewNamingStrategy(strings.ToLower)
+)
+
+// splitWords splits a Go name into words at the case changes and underscores.
+// An acronym is a word: "HTTPServerID" is split into "HTTP", "Server" and "ID".
+// Digits belong to the preceding word: "Version2Name" is split into "Version2" and "Name".
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+
+	start := 0
+	for inx := 0; inx < len(runes); inx++ {
+		r := runes[inx]
+		if r == '_' {
+			if start < inx {
+				words = append(words, string(runes[start:inx]))
+			}
+			start = inx + 1
+			continue
+		}
+		if inx > start && unicode.IsUpper(r) {
+			prev := runes[inx-1]
+			nextLower := inx+1 < len(runes) && unicode.IsLower(runes[inx+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				words = append(words, string(runes[start:inx]))
+				start = inx
+			}
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+func joinWords(words []string, sep string) string {
+	for inx := range words {
+		words[inx] = strings.ToLower(words[inx])
+	}
+	return strings.Join(words, sep)
+}
+
+// toCamelCase lowers the first word of a Go name, and keeps the others.
+func toCamelCase(name string) string {
+	words := splitWords(name)
+	if len(words) == 0 {
+		return name
+	}
+	words[0] = strings.ToLower(words[0])
+	for inx := 1; inx < len(words); inx++ {
+		r, size := utf8.DecodeRuneInString(words[inx])
+		words[inx] = string(unicode.ToUpper(r)) + words[inx][size:]
+	}
+	return strings.Join(words, "")
+}
@@ -0,0 +1,213 @@
+package msgp
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func ExampleNamingStrategy() {
+	type user struct {
+		UserID   int
+		FullName string `msgp:"name"` // a name in the tag wins.
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetNamingStrategy(SnakeCase)
+	if err := e.Encode(&user{UserID: 7, FullName: "Kim"}); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var m map[string]interface{}
+	if err := Unpack(&buf, &m); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(m["user_id"], m["name"])
+	// Output: 7 Kim
+}
+
+func ExampleDecoder_UseCaseInsensitiveKeys() {
+	var buf bytes.Buffer
+	if err := Pack(&buf, map[string]interface{}{"USERID": 7}); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var u struct{ UserID int }
+	d := NewDecoder(&buf)
+	d.UseCaseInsensitiveKeys(true)
+	if err := d.Decode(&u); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(u.UserID)
+	// Output: 7
+}
+
+func TestNamingStrategies(t *testing.T) {
+	tests := []struct {
+		name                     string
+		snake, camel, kebab, low string
+	}{
+		{"Name", "name", "name", "name", "name"},
+		{"UserID", "user_id", "userID", "user-id", "userid"},
+		{"HTTPServer", "http_server", "httpServer", "http-server", "httpserver"},
+		{"HTTPServerID", "http_server_id", "httpServerID", "http-server-id", "httpserverid"},
+		{"Version2Name", "version2_name", "version2Name", "version2-name", "version2name"},
+		{"Field_Name", "field_name", "fieldName", "field-name", "field_name"},
+		{"ID", "id", "id", "id", "id"},
+		{"A", "a", "a", "a", "a"},
+	}
+
+	for _, test := range tests {
+		for _, c := range []struct {
+			naming *NamingStrategy
+			want   string
+		}{
+			{SnakeCase, test.snake},
+			{CamelCase, test.camel},
+			{KebabCase, test.kebab},
+			{LowerCase, test.low},
+		} {
+			if got := c.naming.FieldName(test.name); got != c.want {
+				t.Errorf("%s: got %q, want %q", test.name, got, c.want)
+			}
+		}
+	}
+}
+
+type namedStruct struct {
+	UserID    int
+	FirstName string `msgp:"given"`
+	LastName  string `msgp:",omitempty"`
+	Skipped   string `msgp:"-"`
+}
+
+func TestNamingStrategyRoundTrip(t *testing.T) {
+	upper := NewNamingStrategy(strings.ToUpper)
+	tests := []struct {
+		naming *NamingStrategy
+		keys   []string
+	}{
+		{nil, []string{"UserID", "given", "LastName"}},
+		{SnakeCase, []string{"user_id", "given", "last_name"}},
+		{KebabCase, []string{"user-id", "given", "last-name"}},
+		{upper, []string{"USERID", "given", "LASTNAME"}},
+	}
+
+	in := namedStruct{UserID: 1, FirstName: "a", LastName: "b", Skipped: "c"}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		e.SetNamingStrategy(test.naming)
+		if err := e.Encode(&in); err != nil {
+			t.Fatal(err)
+		}
+
+		var m map[string]interface{}
+		if err := Unpack(bytes.NewReader(buf.Bytes()), &m); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range test.keys {
+			if _, ok := m[key]; !ok {
+				t.Errorf("key %q is not packed: %v", key, m)
+			}
+		}
+		if len(m) != len(test.keys) {
+			t.Errorf("unexpected keys: %v", m)
+		}
+
+		var out namedStruct
+		d := NewDecoder(bytes.NewReader(buf.Bytes()))
+		d.SetNamingStrategy(test.naming)
+		d.UseStrictMode(true)
+		if err := d.Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		if want := (namedStruct{UserID: 1, FirstName: "a", LastName: "b"}); out != want {
+			t.Errorf("got %+v, want %+v", out, want)
+		}
+	}
+
+	// The descriptors of the strategies are cached separately.
+	var buf bytes.Buffer
+	if err := Pack(&buf, &in); err != nil {
+		t.Fatal(err)
+	}
+	var out namedStruct
+	d := NewDecoder(&buf)
+	d.SetNamingStrategy(SnakeCase)
+	if err := d.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.UserID != 0 || out.FirstName != "a" {
+		t.Errorf("unexpected value: %+v", out)
+	}
+}
+
+func TestCaseInsensitiveKeys(t *testing.T) {
+	type target struct {
+		Name  string
+		NAME  string `msgp:"NAME"`
+		Value int    `msgp:"val,required"`
+	}
+
+	pack := func(kvs ...interface{}) []byte {
+		var buf bytes.Buffer
+		PackMapHeader(&buf, len(kvs)/2)
+		for _, kv := range kvs {
+			if err := Pack(&buf, kv); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		data   []byte
+		strict bool
+		want   target
+		fail   bool
+	}{
+		{pack("name", "a", "VAL", 1), false, target{Name: "a", Value: 1}, false},
+		{pack("NAME", "b", "Name", "a", "val", 1), false, target{Name: "a", NAME: "b", Value: 1}, false},
+		{pack("nAmE", "a", "Val", 1), true, target{Name: "a", Value: 1}, false},
+		{pack("name", "a", "value", 1), true, target{}, true}, // unknown key in strict mode
+		{pack("name", "a"), false, target{}, true},            // required field is missing
+	}
+
+	for inx, test := range tests {
+		var out target
+		d := NewDecoder(bytes.NewReader(test.data))
+		d.UseCaseInsensitiveKeys(true)
+		d.UseStrictMode(test.strict)
+		err := d.Decode(&out)
+		if test.fail {
+			if err == nil {
+				t.Errorf("%d: no error", inx)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %v", inx, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, test.want) {
+			t.Errorf("%d: got %+v, want %+v", inx, out, test.want)
+		}
+	}
+
+	// Keys are matched exactly by default.
+	var out target
+	if err := Unpack(bytes.NewReader(pack("name", "a", "val", 1)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "" {
+		t.Errorf("key is matched case-insensitively: %+v", out)
+	}
+}
@@ -5,6 +5,9 @@ import (
 )
 
 // PeekableReader implements one byte buffering for an io.Reader object.
+//
+// Deprecated: The Unpack functions no longer use PeekableReader.
+// Use a Decoder to read values from a stream with buffering.
 type PeekableReader struct {
 	rd   io.Reader // reader provided by the client
 	full bool
@@ -0,0 +1,22 @@
+package msgp
+
+// Raw is the encoded bytes of a single msgpack value of any format family.
+// It can be used to delay the decoding of a part of a message, or to pass it through
+// without decoding and re-encoding.
+// Unpack fills a Raw with a copy of the exact bytes of the value read,
+// and Pack writes the bytes of a Raw as they are. A nil or empty Raw is packed as nil.
+type Raw []byte
+
+// MarshalMsgpack returns the bytes of r, or the bytes of nil if r is empty.
+func (r Raw) MarshalMsgpack() ([]byte, error) {
+	if len(r) == 0 {
+		return []byte{0xc0}, nil
+	}
+	return r, nil
+}
+
+// UnmarshalMsgpack sets *r to a copy of data.
+func (r *Raw) UnmarshalMsgpack(data []byte) error {
+	*r = append((*r)[:0], data...)
+	return nil
+}
@@ -0,0 +1,73 @@
+package msgp
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func ExampleRaw() {
+	type envelope struct {
+		To      string
+		Payload Raw
+	}
+
+	var buf bytes.Buffer
+	Pack(&buf, map[string]interface{}{"To": "node1", "Payload": []int{1, 2, 3}})
+
+	var env envelope
+	Unpack(&buf, &env)
+	fmt.Printf("%s % x\n", env.To, []byte(env.Payload))
+
+	buf.Reset()
+	Pack(&buf, env.Payload)
+	fmt.Printf("% x\n", buf.Bytes())
+
+	// Output:
+	// node1 93 01 02 03
+	// 93 01 02 03
+}
+
+func TestRawRoundTrip(t *testing.T) {
+	var values []interface{}
+	values = append(values, nil, true, -100000, 1.5, "string", []byte("bin"), Extension{3, []byte{1}},
+		[]interface{}{1, "a", []int{2}}, map[string]interface{}{"a": map[string]int{"b": 1}})
+
+	for _, v := range values {
+		var packed bytes.Buffer
+		if err := Pack(&packed, v); err != nil {
+			t.Fatal(err)
+		}
+		packed.WriteByte(0xc3) // next value
+
+		var raw Raw
+		rd := bytes.NewReader(packed.Bytes())
+		if err := Unpack(rd, &raw); err != nil {
+			t.Fatalf("%v: %v", v, err)
+		}
+		if !bytes.Equal(raw, packed.Bytes()[:packed.Len()-1]) {
+			t.Errorf("%v: raw = % x, want % x", v, []byte(raw), packed.Bytes())
+		}
+		if rd.Len() != 1 {
+			t.Errorf("%v: %d bytes remain, want 1", v, rd.Len())
+		}
+
+		var repacked bytes.Buffer
+		if err := Pack(&repacked, raw); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(repacked.Bytes(), raw) {
+			t.Errorf("%v: repacked % x, want % x", v, repacked.Bytes(), []byte(raw))
+		}
+	}
+}
+
+func TestRawEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Pack(&buf, struct{ R Raw }{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x81, 0xa1, 'R', 0xc0}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("packed % x, want % x", buf.Bytes(), want)
+	}
+}
@@ -0,0 +1,341 @@
+package msgp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+var (
+	boolType    = reflect.TypeOf(false)
+	int64Type   = reflect.TypeOf(int64(0))
+	uint64Type  = reflect.TypeOf(uint64(0))
+	float64Type = reflect.TypeOf(float64(0))
+	stringType  = reflect.TypeOf("")
+	bytesType   = reflect.TypeOf([]byte(nil))
+)
+
+// The Read...Bytes functions read a value at the beginning of a byte slice and return
+// the value and the remaining bytes. They don't allocate except for the strings returned,
+// and the returned byte slices refer to the memory of the given slice.
+// If an error occurs, the given slice is returned as the remaining bytes.
+// They return io.EOF for an empty slice, and io.ErrUnexpectedEOF for a truncated value.
+
+// ReadNilBytes reads a nil value and returns true if the next value is nil.
+// Otherwise, it returns false and nothing is read.
+func ReadNilBytes(b []byte) (bool, []byte, error) {
+	if len(b) == 0 {
+		return false, b, io.EOF
+	}
+	if b[0] != 0xc0 {
+		return false, b, nil
+	}
+	return true, b[1:], nil
+}
+
+// ReadBoolBytes reads a bool value. A nil value is read as false.
+func ReadBoolBytes(b []byte) (bool, []byte, error) {
+	if len(b) == 0 {
+		return false, b, io.EOF
+	}
+
+	switch b[0] {
+	case 0xc0, 0xc2:
+		return false, b[1:], nil
+	case 0xc3:
+		return true, b[1:], nil
+	}
+	return false, b, typeError(b[0], 0, boolType, "")
+}
+
+// ReadIntBytes reads a value of the int, uint or float format family as an int64.
+// A nil value is read as 0.
+func ReadIntBytes(b []byte) (int64, []byte, error) {
+	num, rest, err := readNumberBytes(b, int64Type)
+	switch num.kind {
+	case numUint:
+		return int64(num.u), rest, err
+	case numFloat:
+		return int64(num.f), rest, err
+	}
+	return num.i, rest, err
+}
+
+// ReadUintBytes reads a value of the int, uint or float format family as a uint64.
+// A nil value is read as 0.
+func ReadUintBytes(b []byte) (uint64, []byte, error) {
+	num, rest, err := readNumberBytes(b, uint64Type)
+	switch num.kind {
+	case numInt:
+		return uint64(num.i), rest, err
+	case numFloat:
+		return uint64(num.f), rest, err
+	}
+	return num.u, rest, err
+}
+
+// ReadFloatBytes reads a value of the int, uint or float format family as a float64.
+// A nil value is read as 0.
+func ReadFloatBytes(b []byte) (float64, []byte, error) {
+	num, rest, err := readNumberBytes(b, float64Type)
+	switch num.kind {
+	case numInt:
+		return float64(num.i), rest, err
+	case numUint:
+		return float64(num.u), rest, err
+	}
+	return num.f, rest, err
+}
+
+// ReadStringBytes reads a value of the str or bin format family as a string.
+// A nil value is read as an empty string.
+func ReadStringBytes(b []byte) (string, []byte, error) {
+	data, rest, err := readDataBytes(b, stringType, true)
+	return string(data), rest, err
+}
+
+// ReadBinBytes reads a value of the bin format family. A nil value is read as a nil slice.
+// The returned data refers to the memory of 'b'.
+func ReadBinBytes(b []byte) ([]byte, []byte, error) {
+	return readDataBytes(b, bytesType, false)
+}
+
+// ReadArrayHeaderBytes reads the header of an array and returns the number of elements.
+// A nil value is read as an empty array.
+func ReadArrayHeaderBytes(b []byte) (int, []byte, error) {
+	size, kind, rest, err := readHeaderBytes(b)
+	if err == nil && kind == 0x80 {
+		return 0, b, typeError(b[0], 0, nil, "not an array")
+	}
+	return size, rest, err
+}
+
+// ReadMapHeaderBytes reads the header of a map and returns the number of key-value pairs.
+// A nil value is read as an empty map.
+func ReadMapHeaderBytes(b []byte) (int, []byte, error) {
+	size, kind, rest, err := readHeaderBytes(b)
+	if err == nil && kind == 0x90 {
+		return 0, b, typeError(b[0], 0, nil, "not a map")
+	}
+	return size, rest, err
+}
+
+// ReadExtensionBytes reads a value of the ext format family.
+// The data of the returned Extension refers to the memory of 'b'.
+func ReadExtensionBytes(b []byte) (Extension, []byte, error) {
+	size, err := valueSize(b)
+	if err != nil {
+		return Extension{}, b, err
+	}
+	if size > len(b) {
+		return Extension{}, b, io.ErrUnexpectedEOF
+	}
+
+	var data []byte // type and data
+	switch head := b[0]; {
+	case head >= 0xd4 && head <= 0xd8:
+		data = b[1:size]
+	case head == 0xc7:
+		data = b[2:size]
+	case head == 0xc8:
+		data = b[3:size]
+	case head == 0xc9:
+		data = b[5:size]
+	default:
+		return Extension{}, b, typeError(head, 0, extensionType, "")
+	}
+	return Extension{int8(data[0]), data[1:]}, b[size:], nil
+}
+
+// headerSize returns the number of bytes needed for valueSize to tell the size of a value
+// beginning with 'head': the head byte and the length field if any.
+func headerSize(head byte) int {
+	switch head {
+	case 0xd9, 0xc4, 0xc7:
+		return 2
+	case 0xda, 0xc5, 0xc8, 0xdc, 0xde:
+		return 3
+	case 0xdb, 0xc6, 0xc9, 0xdd, 0xdf:
+		return 5
+	}
+	return 1
+}
+
+// valueSize returns the size of the value at the beginning of 'b', not including the elements
+// of arrays and maps. If 'b' is too short to contain the length field of the value, it returns
+// the size up to the length field instead, so that the caller can retry with more bytes.
+func valueSize(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, io.EOF
+	}
+
+	head := b[0]
+	switch {
+	case head <= 0x7f || head >= 0xe0 || head == 0xc0 || head == 0xc2 || head == 0xc3:
+		return 1, nil
+	case head&0xe0 == 0xa0: // fixstr
+		return 1 + int(head&0x1f), nil
+	case head&0xf0 == 0x90 || head&0xf0 == 0x80: // fixarray, fixmap
+		return 1, nil
+	case head == 0xcc || head == 0xd0:
+		return 2, nil
+	case head == 0xcd || head == 0xd1 || head == 0xdc || head == 0xde:
+		return 3, nil
+	case head == 0xce || head == 0xd2 || head == 0xca || head == 0xdd || head == 0xdf:
+		return 5, nil
+	case head == 0xcf || head == 0xd3 || head == 0xcb:
+		return 9, nil
+	case head >= 0xd4 && head <= 0xd8: // fixext
+		return 2 + 1<<(head-0xd4), nil
+	case head == 0xd9 || head == 0xc4 || head == 0xc7:
+		if len(b) < 2 {
+			return 2, nil
+		}
+		if head == 0xc7 {
+			return 3 + int(b[1]), nil
+		}
+		return 2 + int(b[1]), nil
+	case head == 0xda || head == 0xc5 || head == 0xc8:
+		if len(b) < 3 {
+			return 3, nil
+		}
+		if head == 0xc8 {
+			return 4 + int(binary.BigEndian.Uint16(b[1:])), nil
+		}
+		return 3 + int(binary.BigEndian.Uint16(b[1:])), nil
+	case head == 0xdb || head == 0xc6 || head == 0xc9:
+		if len(b) < 5 {
+			return 5, nil
+		}
+		if head == 0xc9 {
+			return 6 + int(binary.BigEndian.Uint32(b[1:])), nil
+		}
+		return 5 + int(binary.BigEndian.Uint32(b[1:])), nil
+	}
+	return 0, fmt.Errorf("msgp: unknown format[0x%02x] was found", head)
+}
+
+const (
+	numNil = iota
+	numInt
+	numUint
+	numFloat
+)
+
+type number struct {
+	kind int
+	i    int64
+	u    uint64
+	f    float64
+}
+
+// readNumberBytes reads a value of the int, uint or float format family.
+// 'typ' is used for the error.
+func readNumberBytes(b []byte, typ reflect.Type) (number, []byte, error) {
+	var num number
+
+	size, err := valueSize(b)
+	if err != nil {
+		return num, b, err
+	}
+	if size > len(b) {
+		return num, b, io.ErrUnexpectedEOF
+	}
+
+	head, p := b[0], b[1:size]
+	switch {
+	case head == 0xc0:
+	case head&0x80 == 0, head&0xe0 == 0xe0:
+		num.kind, num.i = numInt, int64(int8(head))
+	case head == 0xd0:
+		num.kind, num.i = numInt, int64(int8(p[0]))
+	case head == 0xd1:
+		num.kind, num.i = numInt, int64(int16(binary.BigEndian.Uint16(p)))
+	case head == 0xd2:
+		num.kind, num.i = numInt, int64(int32(binary.BigEndian.Uint32(p)))
+	case head == 0xd3:
+		num.kind, num.i = numInt, int64(binary.BigEndian.Uint64(p))
+	case head == 0xcc:
+		num.kind, num.u = numUint, uint64(p[0])
+	case head == 0xcd:
+		num.kind, num.u = numUint, uint64(binary.BigEndian.Uint16(p))
+	case head == 0xce:
+		num.kind, num.u = numUint, uint64(binary.BigEndian.Uint32(p))
+	case head == 0xcf:
+		num.kind, num.u = numUint, binary.BigEndian.Uint64(p)
+	case head == 0xca:
+		num.kind, num.f = numFloat, float64(math.Float32frombits(binary.BigEndian.Uint32(p)))
+	case head == 0xcb:
+		num.kind, num.f = numFloat, math.Float64frombits(binary.BigEndian.Uint64(p))
+	default:
+		return number{}, b, typeError(head, 0, typ, "")
+	}
+	return num, b[size:], nil
+}
+
+// readDataBytes returns the data of a bin value, or of a str value if 'str' is true.
+// A nil value is read as nil data. 'typ' is used for the error.
+func readDataBytes(b []byte, typ reflect.Type, str bool) ([]byte, []byte, error) {
+	size, err := valueSize(b)
+	if err != nil {
+		return nil, b, err
+	}
+	if size > len(b) {
+		return nil, b, io.ErrUnexpectedEOF
+	}
+
+	var data []byte
+	switch head := b[0]; {
+	case head == 0xc0:
+	case head == 0xc4:
+		data = b[2:size]
+	case head == 0xc5:
+		data = b[3:size]
+	case head == 0xc6:
+		data = b[5:size]
+	case str && head&0xe0 == 0xa0:
+		data = b[1:size]
+	case str && head == 0xd9:
+		data = b[2:size]
+	case str && head == 0xda:
+		data = b[3:size]
+	case str && head == 0xdb:
+		data = b[5:size]
+	default:
+		return nil, b, typeError(head, 0, typ, "")
+	}
+	if len(data) == 0 {
+		data = nil // nil as an empty slice
+	}
+	return data, b[size:], nil
+}
+
+// readHeaderBytes reads the header of a map or an array. It returns the size and
+// 0x80 for a map, 0x90 for an array, or 0xc0 for a nil value.
+func readHeaderBytes(b []byte) (int, byte, []byte, error) {
+	size, err := valueSize(b)
+	if err != nil {
+		return 0, 0, b, err
+	}
+	if size > len(b) {
+		return 0, 0, b, io.ErrUnexpectedEOF
+	}
+
+	switch head := b[0]; {
+	case head == 0xc0:
+		return 0, head, b[1:], nil
+	case head&0xf0 == 0x80, head&0xf0 == 0x90:
+		return int(head & 0x0f), head & 0xf0, b[1:], nil
+	case head == 0xde:
+		return int(binary.BigEndian.Uint16(b[1:])), 0x80, b[3:], nil
+	case head == 0xdc:
+		return int(binary.BigEndian.Uint16(b[1:])), 0x90, b[3:], nil
+	case head == 0xdf:
+		return int(binary.BigEndian.Uint32(b[1:])), 0x80, b[5:], nil
+	case head == 0xdd:
+		return int(binary.BigEndian.Uint32(b[1:])), 0x90, b[5:], nil
+	}
+	return 0, 0, b, typeError(b[0], 0, nil, "not a map or an array")
+}
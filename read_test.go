@@ -0,0 +1,121 @@
+package msgp
+
+import (
+	"fmt"
+	"io"
+	"testing"
+)
+
+func ExampleReadIntBytes() {
+	b := []byte{0x82, 0xa2, 0x69, 0x64, 0xd1, 0x01, 0x2c, 0xa2, 0x6f, 0x6b, 0xc3}
+
+	size, b, _ := ReadMapHeaderBytes(b)
+	key, b, _ := ReadStringBytes(b)
+	id, b, _ := ReadIntBytes(b)
+	fmt.Println(size, key, id)
+
+	key, b, _ = ReadStringBytes(b)
+	ok, b, _ := ReadBoolBytes(b)
+	fmt.Println(key, ok, len(b))
+
+	// Output:
+	// 2 id 300
+	// ok true 0
+}
+
+func TestReadBytesRoundTrip(t *testing.T) {
+	ints := []int64{0, 0x7f, 0x80, 0xffff, 0x10000, 0x100000000, -1, -33, -0x8001, -0x80000001}
+	for _, v := range ints {
+		b := AppendInt(nil, v)
+		if got, rest, err := ReadIntBytes(b); got != v || len(rest) != 0 || err != nil {
+			t.Errorf("ReadIntBytes(% x) = %v, % x, %v", b, got, rest, err)
+		}
+		b = AppendUint(nil, uint64(v))
+		if got, rest, err := ReadUintBytes(b); got != uint64(v) || len(rest) != 0 || err != nil {
+			t.Errorf("ReadUintBytes(% x) = %v, % x, %v", b, got, rest, err)
+		}
+	}
+
+	b := AppendFloat32(nil, 1.5)
+	if got, _, err := ReadFloatBytes(b); got != 1.5 || err != nil {
+		t.Errorf("ReadFloatBytes(% x) = %v, %v", b, got, err)
+	}
+	if got, _, err := ReadIntBytes(b); got != 1 || err != nil {
+		t.Errorf("ReadIntBytes(% x) = %v, %v", b, got, err)
+	}
+
+	b = AppendBin(nil, []byte("bin"))
+	if got, _, err := ReadBinBytes(b); string(got) != "bin" || &got[0] != &b[2] || err != nil {
+		t.Errorf("ReadBinBytes(% x) = %v, %v", b, got, err)
+	}
+	if got, _, err := ReadStringBytes(b); got != "bin" || err != nil {
+		t.Errorf("ReadStringBytes(% x) = %v, %v", b, got, err)
+	}
+
+	b = AppendExtension(nil, Extension{-3, []byte{1, 2, 3}})
+	if got, _, err := ReadExtensionBytes(b); got.Type != -3 || string(got.Data) != "\x01\x02\x03" || err != nil {
+		t.Errorf("ReadExtensionBytes(% x) = %v, %v", b, got, err)
+	}
+
+	b = AppendArrayHeader(nil, 0x10000)
+	if got, _, err := ReadArrayHeaderBytes(b); got != 0x10000 || err != nil {
+		t.Errorf("ReadArrayHeaderBytes(% x) = %v, %v", b, got, err)
+	}
+	if _, rest, err := ReadMapHeaderBytes(b); err == nil || len(rest) != len(b) {
+		t.Errorf("ReadMapHeaderBytes(% x) reads an array", b)
+	}
+}
+
+func TestReadBytesErrors(t *testing.T) {
+	if _, _, err := ReadIntBytes(nil); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+
+	for _, b := range [][]byte{
+		{0xd1, 0x01},
+		{0xd9},
+		{0xa3, 'a', 'b'},
+		{0xc5, 0x00, 0x03, 1, 2},
+		{0xdd, 0x00},
+	} {
+		var err error
+		switch {
+		case b[0] == 0xd1:
+			_, _, err = ReadIntBytes(b)
+		case b[0] == 0xc5:
+			_, _, err = ReadBinBytes(b)
+		case b[0] == 0xdd:
+			_, _, err = ReadArrayHeaderBytes(b)
+		default:
+			_, _, err = ReadStringBytes(b)
+		}
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("% x: err = %v, want io.ErrUnexpectedEOF", b, err)
+		}
+	}
+
+	b := []byte{0xc3}
+	if _, rest, err := ReadIntBytes(b); err == nil || len(rest) != 1 {
+		t.Errorf("ReadIntBytes(% x) = % x, %v", b, rest, err)
+	}
+}
+
+func TestReadBytesDoesNotAllocate(t *testing.T) {
+	b := AppendArrayHeader(nil, 4)
+	b = AppendInt(b, -100000)
+	b = AppendFloat64(b, 1.5)
+	b = AppendBin(b, []byte("data"))
+	b = AppendBool(b, true)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		rest := b
+		_, rest, _ = ReadArrayHeaderBytes(rest)
+		_, rest, _ = ReadIntBytes(rest)
+		_, rest, _ = ReadFloatBytes(rest)
+		_, rest, _ = ReadBinBytes(rest)
+		_, rest, _ = ReadBoolBytes(rest)
+	})
+	if allocs != 0 {
+		t.Errorf("allocs = %v, want 0", allocs)
+	}
+}
@@ -0,0 +1,136 @@
+package msgp
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var typeRegistry = struct {
+	sync.RWMutex
+	byName map[string]reflect.Type
+	byType map[reflect.Type]string
+}{
+	byName: make(map[string]reflect.Type),
+	byType: make(map[reflect.Type]string),
+}
+
+// RegisterType maps 'name' to the Go type of 'proto' for the values of interface types.
+//
+// A value of a registered type in a variable of an interface type with methods, such as a
+// struct field 'Shape Shape' or an element of []Shape, is packed as an array of two elements,
+// the name and the value. Such an array is unpacked into the interface type as a new value of
+// the registered type, which must implement the interface. The type of 'proto' is used as it is
+// for unpacking, so register &Circle{} if only *Circle implements the interface. For packing,
+// a value of *Circle is found by Circle{} registered, and a value of Circle by &Circle{}.
+//
+// Values of unregistered types are packed without the name, and cannot be unpacked into the
+// interface types. The values in variables of the empty interface type are always packed
+// without the name. Note that Pack(w, shape) cannot see the interface type; use Pack(w, &shape).
+// Registering the same name or Go type again replaces the previous registration.
+func RegisterType(name string, proto interface{}) {
+	typ := reflect.TypeOf(proto)
+
+	typeRegistry.Lock()
+	defer typeRegistry.Unlock()
+
+	if old, ok := typeRegistry.byName[name]; ok {
+		delete(typeRegistry.byType, old)
+	}
+	if old, ok := typeRegistry.byType[typ]; ok {
+		delete(typeRegistry.byName, old)
+	}
+	typeRegistry.byName[name] = typ
+	typeRegistry.byType[typ] = name
+}
+
+func registeredTypeName(typ reflect.Type) (string, bool) {
+	typeRegistry.RLock()
+	defer typeRegistry.RUnlock()
+	return lookupType(typeRegistry.byType, typ)
+}
+
+// lookupType returns the name of a type in 'byType'. If the type itself is not found,
+// the element type of a pointer type, or the pointer type to a type is looked up,
+// so that &Circle{} is found when Circle{} is registered, and vice versa.
+func lookupType(byType map[reflect.Type]string, typ reflect.Type) (string, bool) {
+	if name, ok := byType[typ]; ok {
+		return name, true
+	}
+	if typ.Kind() == reflect.Ptr {
+		name, ok := byType[typ.Elem()]
+		return name, ok
+	}
+	name, ok := byType[reflect.PtrTo(typ)]
+	return name, ok
+}
+
+func registeredType(name string) reflect.Type {
+	typeRegistry.RLock()
+	defer typeRegistry.RUnlock()
+	return typeRegistry.byName[name]
+}
+
+// packRegisteredType writes the value of a registered type in an interface value with its name.
+func packRegisteredType(e *Encoder, name string, v reflect.Value) error {
+	var err error
+
+	if err = PackArrayHeader(e, 2); err != nil {
+		return err
+	}
+	if err = PackString(e, name); err != nil {
+		return err
+	}
+	return packValue(e, v)
+}
+
+// unpackRegisteredType reads an array of a type name and a value into the interface value
+// pointed by 'ptr'. The interface type must have methods.
+func unpackRegisteredType(d *Decoder, ptr interface{}) error {
+	wantType := reflect.TypeOf(ptr).Elem()
+
+	head, err := d.peekByte()
+	if err != nil {
+		return err
+	}
+	if head == 0xc0 { // nil value unpacked.
+		d.r++
+		reflect.ValueOf(ptr).Elem().Set(reflect.Zero(wantType))
+		return nil
+	}
+
+	offset := d.offset()
+	size, err := d.ReadArrayHeader()
+	if _, ok := err.(*UnpackTypeError); ok || (err == nil && size != 2) {
+		return typeError(head, offset, wantType, "not an array of a registered type name and a value")
+	} else if err != nil {
+		return err
+	}
+	if err = d.enter(size); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	if head, err = d.peekByte(); err != nil {
+		return err
+	}
+	offset = d.offset()
+	name, err := d.ReadString()
+	if err != nil {
+		return err
+	}
+	typ := registeredType(name)
+	if typ == nil {
+		return typeError(head, offset, wantType, fmt.Sprintf("type name %q is not registered", name))
+	}
+	if !typ.Implements(wantType) {
+		return typeError(head, offset, wantType, fmt.Sprintf("registered type %v of %q does not implement it", typ, name))
+	}
+
+	val := reflect.New(typ)
+	if err = Unpack(d, val.Interface()); err != nil {
+		return err
+	}
+	reflect.ValueOf(ptr).Elem().Set(val.Elem())
+	return nil
+}
@@ -0,0 +1,178 @@
+package msgp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type shape interface {
+	Area() float64
+}
+
+type circle struct {
+	Radius float64 `msgp:"r"`
+}
+
+func (c circle) Area() float64 { return math.Pi * c.Radius * c.Radius }
+
+type rect struct {
+	W, H float64
+}
+
+func (r *rect) Area() float64 { return r.W * r.H }
+
+type square float64
+
+func (s square) Area() float64 { return float64(s * s) }
+
+type drawing struct {
+	Main   shape
+	Shapes []shape
+	ByName map[string]shape
+	None   shape
+}
+
+func init() {
+	RegisterType("circle", circle{})
+	RegisterType("rect", &rect{})
+	RegisterType("square", square(0))
+}
+
+func ExampleRegisterType() {
+	// RegisterType("circle", circle{})
+	// RegisterType("rect", &rect{})
+	var buf bytes.Buffer
+	Pack(&buf, drawing{Main: circle{Radius: 1}, Shapes: []shape{&rect{W: 2, H: 3}}})
+
+	var d drawing
+	Unpack(&buf, &d)
+	fmt.Printf("%T %v\n", d.Main, d.Main)
+	fmt.Printf("%T %v\n", d.Shapes[0], d.Shapes[0])
+
+	// Output:
+	// msgp.circle {1}
+	// *msgp.rect &{2 3}
+}
+
+func TestRegisteredTypeRoundTrip(t *testing.T) {
+	in := drawing{
+		Main:   square(3),
+		Shapes: []shape{circle{Radius: 2}, &rect{W: 1, H: 2}, nil, square(1)},
+		ByName: map[string]shape{"c": circle{Radius: 0.5}, "r": &rect{}},
+	}
+
+	var buf bytes.Buffer
+	if err := Pack(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	var out drawing
+	if err := Unpack(&buf, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got %#v, want %#v", out, in)
+	}
+
+	// a variable of an interface type
+	var s shape = circle{Radius: 4}
+	buf.Reset()
+	if err := Pack(&buf, &s); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x92, 0xa6, 'c', 'i', 'r', 'c', 'l', 'e', 0x81, 0xa1, 'r', 0xcb}
+	if !bytes.HasPrefix(buf.Bytes(), want) {
+		t.Errorf("packed % x", buf.Bytes())
+	}
+	var got shape
+	if err := Unpack(&buf, &got); err != nil || got != s {
+		t.Errorf("got %v, err %v", got, err)
+	}
+}
+
+func TestRegisteredTypePointer(t *testing.T) {
+	// &circle{} is packed by the name of circle{}, and is unpacked as circle.
+	in := drawing{Shapes: []shape{&circle{Radius: 1}}}
+
+	var buf bytes.Buffer
+	if err := Pack(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	var out drawing
+	if err := Unpack(&buf, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := []shape{circle{Radius: 1}}; !reflect.DeepEqual(out.Shapes, want) {
+		t.Errorf("got %#v, want %#v", out.Shapes, want)
+	}
+}
+
+func TestRegisteredTypeEmptyInterface(t *testing.T) {
+	// the name is not packed for the empty interface.
+	var buf bytes.Buffer
+	if err := Pack(&buf, []interface{}{square(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x91, 0xcb, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}; !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("packed % x, want % x", buf.Bytes(), want)
+	}
+}
+
+type triangle struct{ B, H float64 }
+
+func (t triangle) Area() float64 { return t.B * t.H / 2 }
+
+type notShape struct{}
+
+func TestRegisteredTypeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		reason string
+	}{
+		{"not an array", AppendInt(nil, 1), "not an array of a registered type name and a value"},
+		{"wrong size", AppendArrayHeader(nil, 1), "not an array of a registered type name and a value"},
+		{"unregistered", AppendNil(AppendString(AppendArrayHeader(nil, 2), "hexagon")), `type name "hexagon" is not registered`},
+		{"not implemented", AppendNil(AppendString(AppendArrayHeader(nil, 2), "notshape")), `registered type msgp.notShape of "notshape" does not implement it`},
+	}
+	RegisterType("notshape", notShape{})
+
+	for _, test := range tests {
+		var d drawing
+		data := append(AppendString(AppendMapHeader(nil, 1), "Main"), test.data...)
+		err := Unpack(bytes.NewReader(data), &d)
+
+		var te *UnpackTypeError
+		if !errors.As(err, &te) || te.Field != "drawing.Main" || te.Reason != test.reason {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+	}
+
+	// the values of unregistered types are packed without the name.
+	var buf bytes.Buffer
+	if err := Pack(&buf, drawing{Main: triangle{B: 1, H: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	var d drawing
+	err := Unpack(&buf, &d)
+	if err == nil || !strings.Contains(err.Error(), "fixmap into Go value drawing.Main") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestRegisterTypeReplace(t *testing.T) {
+	defer RegisterType("square", square(0))
+
+	type square2 float64
+	RegisterType("square", square2(0))
+	if _, ok := registeredTypeName(reflect.TypeOf(square(0))); ok {
+		t.Error("previous type is still registered")
+	}
+	if typ := registeredType("square"); typ != reflect.TypeOf(square2(0)) {
+		t.Errorf("got %v", typ)
+	}
+}
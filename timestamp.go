@@ -0,0 +1,69 @@
+package msgp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// TimestampExtType is the extension type code of the timestamp defined by the msgpack spec.
+// time.Time values are packed as timestamp extension values, and timestamp extension values
+// are unpacked into time.Time values in UTC.
+const TimestampExtType int8 = -1
+
+func init() {
+	RegisterExt(TimestampExtType, time.Time{}, encodeTimestamp, decodeTimestamp)
+}
+
+// encodeTimestamp returns the data of the smallest timestamp format(32, 64 or 96) for a time.Time value.
+func encodeTimestamp(v interface{}) ([]byte, error) {
+	t := v.(time.Time)
+	sec := t.Unix()
+	nsec := int64(t.Nanosecond())
+
+	if sec>>34 == 0 {
+		data64 := uint64(nsec)<<34 | uint64(sec)
+		if data64&0xffffffff00000000 == 0 { // timestamp 32
+			data := make([]byte, 4)
+			binary.BigEndian.PutUint32(data, uint32(data64))
+			return data, nil
+		}
+
+		// timestamp 64
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, data64)
+		return data, nil
+	}
+
+	// timestamp 96
+	data := make([]byte, 12)
+	binary.BigEndian.PutUint32(data, uint32(nsec))
+	binary.BigEndian.PutUint64(data[4:], uint64(sec))
+	return data, nil
+}
+
+// decodeTimestamp decodes the data of a timestamp extension value into a time.Time value.
+func decodeTimestamp(data []byte, ptr interface{}) error {
+	var sec, nsec int64
+
+	switch len(data) {
+	case 4: // timestamp 32
+		sec = int64(binary.BigEndian.Uint32(data))
+	case 8: // timestamp 64
+		data64 := binary.BigEndian.Uint64(data)
+		nsec = int64(data64 >> 34)
+		sec = int64(data64 & 0x00000003ffffffff)
+	case 12: // timestamp 96
+		nsec = int64(binary.BigEndian.Uint32(data))
+		sec = int64(binary.BigEndian.Uint64(data[4:]))
+	default:
+		return fmt.Errorf("msgp: invalid timestamp data length[%d]", len(data))
+	}
+
+	if nsec > 999999999 {
+		return fmt.Errorf("msgp: invalid timestamp nanoseconds[%d]", nsec)
+	}
+
+	*ptr.(*time.Time) = time.Unix(sec, nsec).UTC()
+	return nil
+}
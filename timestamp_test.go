@@ -0,0 +1,87 @@
+package msgp
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func ExamplePack_time() {
+	var buf bytes.Buffer
+
+	Pack(&buf, time.Unix(1, 0))
+	fmt.Printf("% x\n", buf.Bytes())
+
+	buf.Reset()
+
+	Pack(&buf, time.Unix(1, 1))
+	fmt.Printf("% x\n", buf.Bytes())
+
+	buf.Reset()
+
+	Pack(&buf, time.Unix(-1, 0))
+	fmt.Printf("% x\n", buf.Bytes())
+
+	// Output:
+	// d6 ff 00 00 00 01
+	// d7 ff 00 00 00 04 00 00 00 01
+	// c7 0c ff 00 00 00 00 ff ff ff ff ff ff ff ff
+}
+
+func TestTimestampRoundTrip(t *testing.T) {
+	for _, src := range []time.Time{
+		time.Unix(0, 0),
+		time.Unix(1<<32-1, 0),
+		time.Unix(1<<32, 0),
+		time.Unix(1<<34-1, 999999999),
+		time.Unix(1<<34, 0),
+		time.Unix(-1, 500),
+		time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2020, 2, 29, 12, 34, 56, 789, time.FixedZone("KST", 9*60*60)),
+	} {
+		var buf bytes.Buffer
+		if err := Pack(&buf, src); err != nil {
+			t.Fatalf("%v: %v", src, err)
+		}
+
+		var dst time.Time
+		if err := Unpack(bytes.NewReader(buf.Bytes()), &dst); err != nil {
+			t.Fatalf("%v: %v", src, err)
+		}
+		if !dst.Equal(src) {
+			t.Errorf("unpacked %v, want %v", dst, src)
+		}
+
+		var unknown interface{}
+		if err := Unpack(bytes.NewReader(buf.Bytes()), &unknown); err != nil {
+			t.Fatalf("%v: %v", src, err)
+		}
+		if tm, ok := unknown.(time.Time); !ok || !tm.Equal(src) {
+			t.Errorf("unpacked %T %v, want %v", unknown, unknown, src)
+		}
+	}
+}
+
+func TestTimestampInStruct(t *testing.T) {
+	type event struct {
+		Name    string
+		At      time.Time
+		Expires *time.Time
+	}
+
+	var buf bytes.Buffer
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	src := event{"start", time.Date(2020, 1, 1, 9, 0, 0, 100, time.UTC), &expires}
+	if err := Pack(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	var dst event
+	if err := Unpack(&buf, &dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Name != src.Name || !dst.At.Equal(src.At) || dst.Expires == nil || !dst.Expires.Equal(expires) {
+		t.Errorf("unpacked %+v, want %+v", dst, src)
+	}
+}
@@ -0,0 +1,213 @@
+package msgp
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// structInfo is the compiled descriptor of a struct type.
+// It is built once for a struct type and a tag name, and shared by Pack and Unpack.
+type structInfo struct {
+	fields  []structField
+	byName  map[string]*structField // fields by the packed name
+	asArray bool                    // whether the type has the asarray marker field
+
+	hasRequired bool // whether any field has the required option
+	hasDefault  bool // whether any field has the default option
+
+	byFoldedName map[string]*structField // fields by the lower-cased packed name, for case-insensitive keys
+}
+
+type structInfoKey struct {
+	typ     reflect.Type
+	tagName string
+	naming  *NamingStrategy
+}
+
+var structInfoCache sync.Map // map[structInfoKey]*structInfo
+
+// cachedStructInfo returns the descriptor of a struct type for the tag name and the naming strategy.
+// 'naming' may be nil for the Go names. It is safe for concurrent use.
+func cachedStructInfo(typ reflect.Type, tagName string, naming *NamingStrategy) *structInfo {
+	key := structInfoKey{typ, tagName, naming}
+	if si, ok := structInfoCache.Load(key); ok {
+		return si.(*structInfo)
+	}
+	si, _ := structInfoCache.LoadOrStore(key, newStructInfo(typ, tagName, naming))
+	return si.(*structInfo)
+}
+
+// clearStructInfoCache drops all descriptors.
+// It is called when the ext registry changes because the embedded fields are flattened
+// depending on the registered types.
+func clearStructInfoCache() {
+	structInfoCache.Range(func(key, _ interface{}) bool {
+		structInfoCache.Delete(key)
+		return true
+	})
+}
+
+func newStructInfo(typ reflect.Type, tagName string, naming *NamingStrategy) *structInfo {
+	si := &structInfo{
+		fields:  structFields(typ, tagName, naming),
+		asArray: isStructAsArray(typ, tagName),
+	}
+
+	si.byName = make(map[string]*structField, len(si.fields))
+	si.byFoldedName = make(map[string]*structField, len(si.fields))
+	for inx := range si.fields {
+		sf := &si.fields[inx]
+		sf.seq = inx
+		if sf.Props.Required {
+			si.hasRequired = true
+		}
+		if sf.Props.OmitZero {
+			sf.isZero = zeroFunc(sf.Type)
+		}
+		if sf.Props.HasDefault {
+			si.hasDefault = true
+			sf.defaultValue, sf.defaultErr = convertDefault(typ, sf)
+		}
+		if sf.Props.String {
+			sf.pack = stringFieldPacker(typ, sf)
+			sf.unpack = unpackStringField
+		} else {
+			sf.pack = packValue
+			sf.unpack = unpackValue
+		}
+		si.byName[sf.Props.Name] = sf
+		if folded := strings.ToLower(sf.Props.Name); si.byFoldedName[folded] == nil {
+			si.byFoldedName[folded] = sf
+		}
+	}
+	return si
+}
+
+// stringFieldPacker returns the function that writes the value of a field with the string option.
+func stringFieldPacker(structTyp reflect.Type, sf *structField) func(e *Encoder, v reflect.Value) error {
+	name, typ := sf.Name, sf.Type
+	return func(e *Encoder, fieldValue reflect.Value) error {
+		for {
+			switch fieldValue.Kind() {
+			case reflect.Bool:
+				return PackString(e, strconv.FormatBool(fieldValue.Bool()))
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				return PackString(e, strconv.FormatInt(fieldValue.Int(), 10))
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				return PackString(e, strconv.FormatUint(fieldValue.Uint(), 10))
+			case reflect.Float32:
+				return PackString(e, strconv.FormatFloat(fieldValue.Float(), 'g', -1, 32))
+			case reflect.Float64:
+				return PackString(e, strconv.FormatFloat(fieldValue.Float(), 'g', -1, 64))
+			case reflect.Ptr:
+				if fieldValue.IsNil() {
+					return PackString(e, "nil")
+				}
+				fieldValue = fieldValue.Elem()
+			default:
+				return fmt.Errorf("msgp: cannot pack Go struct field %v.%s of type %v into string", structTyp, name, typ)
+			}
+		}
+	}
+}
+
+// unpackStringField reads a str value into a field with the string option.
+func unpackStringField(d *Decoder, fieldVal reflect.Value) error {
+	var str string
+
+	head, err := d.peekByte()
+	if err != nil {
+		return err
+	}
+	offset := d.offset()
+
+	if err = Unpack(d, &str); err != nil {
+		return err
+	}
+	if err = assignValueFromString(fieldVal, str); err != nil {
+		return typeError(head, offset, fieldVal.Type(), err.Error())
+	}
+	return nil
+}
+
+// unpackValue reads a value into the addressable 'v'.
+func unpackValue(d *Decoder, v reflect.Value) error {
+	return Unpack(d, v.Addr().Interface())
+}
+
+// convertDefault returns the value of the default option of a field.
+// The value is checked once here; it is converted again whenever it is assigned,
+// so that a pointer field never shares the value with other structs.
+func convertDefault(structTyp reflect.Type, sf *structField) (reflect.Value, error) {
+	typ := sf.Type
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return reflect.Value{}, fmt.Errorf("msgp: default option is not supported for field %s.%s of type %v", structTyp, sf.Name, sf.Type)
+	}
+
+	v := reflect.New(sf.Type).Elem()
+	if err := assignValueFromString(v, sf.Props.Default); err != nil {
+		return reflect.Value{}, fmt.Errorf("msgp: invalid default value %q for field %s.%s: %v", sf.Props.Default, structTyp, sf.Name, err)
+	}
+	return v, nil
+}
+
+// setDefaults assigns the default values to the fields which are not seen.
+func (si *structInfo) setDefaults(structVal reflect.Value, seen []bool) error {
+	for inx := range si.fields {
+		sf := &si.fields[inx]
+		if !sf.Props.HasDefault || seen[inx] {
+			continue
+		}
+		if sf.defaultErr != nil {
+			return sf.defaultErr
+		}
+		fieldVal, err := fieldByIndexAlloc(structVal, sf.Index)
+		if err != nil {
+			return err
+		}
+		if err = assignValueFromString(fieldVal, sf.Props.Default); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isDefault reports whether a field value equals the value of the default option.
+func (sf *structField) isDefault(v reflect.Value) bool {
+	return sf.defaultErr == nil && reflect.DeepEqual(v.Interface(), sf.defaultValue.Interface())
+}
+
+// fieldByKey returns the field for a key of a map. If 'foldCase' is true and no field has
+// the exact name, the first field whose name matches the key case-insensitively is returned.
+func (si *structInfo) fieldByKey(key string, foldCase bool) (*structField, bool) {
+	sf, ok := si.byName[key]
+	if !ok && foldCase {
+		sf, ok = si.byFoldedName[strings.ToLower(key)]
+	}
+	return sf, ok
+}
+
+// missingFields returns a MissingFieldsError for the required fields which are not seen,
+// or nil if there is none. 'seen' is indexed by the position of the fields.
+func (si *structInfo) missingFields(typ reflect.Type, seen []bool) error {
+	var keys []string
+	for inx := range si.fields {
+		if si.fields[inx].Props.Required && !seen[inx] {
+			keys = append(keys, si.fields[inx].Props.Name)
+		}
+	}
+	if keys != nil {
+		return &MissingFieldsError{Type: typ, Keys: keys}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package msgp
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type cachedStruct struct {
+	Name  string `msgp:"name" json:"n"`
+	Count int    `msgp:",string"`
+}
+
+func TestStructInfoCached(t *testing.T) {
+	typ := reflect.TypeOf(cachedStruct{})
+
+	si := cachedStructInfo(typ, defaultTagName, nil)
+	if cachedStructInfo(typ, defaultTagName, nil) != si {
+		t.Fatal("struct info is not cached")
+	}
+	if si.byName["name"] == nil || si.byName["Count"] == nil {
+		t.Fatalf("unexpected fields: %v", si.byName)
+	}
+
+	jsonInfo := cachedStructInfo(typ, "json", nil)
+	if jsonInfo == si || jsonInfo.byName["n"] == nil {
+		t.Fatal("struct info is not distinguished by tag name")
+	}
+}
+
+func TestStructInfoConcurrent(t *testing.T) {
+	clearStructInfoCache()
+
+	var wg sync.WaitGroup
+	for inx := 0; inx < 8; inx++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			var buf bytes.Buffer
+			var out cachedStruct
+			if err := Pack(&buf, cachedStruct{"a", n}); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := Unpack(&buf, &out); err != nil {
+				t.Error(err)
+				return
+			}
+			if out.Name != "a" || out.Count != n {
+				t.Errorf("unexpected value: %+v", out)
+			}
+		}(inx)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,174 @@
+package msgp
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+type unionInfo struct {
+	key    string                  // discriminator key
+	byTag  map[string]reflect.Type // value of the key -> Go type
+	byType map[reflect.Type]string // Go type -> value of the key
+}
+
+var unionRegistry = struct {
+	sync.RWMutex
+	byIface map[reflect.Type]*unionInfo
+}{
+	byIface: make(map[reflect.Type]*unionInfo),
+}
+
+// RegisterUnion declares the interface type pointed by 'iface' as a discriminated union of
+// struct types. A value of the interface type is packed as a map of the fields of the struct
+// with the discriminator 'key' first, and the str value of the key selects the struct type
+// to unpack a map into, wherever the key is in the map. 'types' maps the values of the key to
+// prototypes of the struct types, e.g. ClickEvent{} or &ClickEvent{}, which must implement
+// the interface. The discriminator key is not an unknown key in strict mode, and it is also
+// assigned to the field of the struct with the name, if any. Such a field is not packed;
+// the key is always written with the value of the case.
+//
+//	msgp.RegisterUnion((*Event)(nil), "type", map[string]interface{}{
+//		"click": ClickEvent{},
+//		"key":   &KeyEvent{},
+//	})
+//
+// A value of *ClickEvent is packed as the case of ClickEvent{}, and vice versa; a value
+// of the other types is an error. A union takes precedence over the names of RegisterType
+// for the interface type.
+// Registering the same interface type again replaces the previous registration.
+func RegisterUnion(iface interface{}, key string, types map[string]interface{}) {
+	u := &unionInfo{
+		key:    key,
+		byTag:  make(map[string]reflect.Type, len(types)),
+		byType: make(map[reflect.Type]string, len(types)),
+	}
+	for tag, proto := range types {
+		typ := reflect.TypeOf(proto)
+		u.byTag[tag] = typ
+		u.byType[typ] = tag
+	}
+
+	unionRegistry.Lock()
+	defer unionRegistry.Unlock()
+	unionRegistry.byIface[reflect.TypeOf(iface).Elem()] = u
+}
+
+func unionInfoOf(typ reflect.Type) *unionInfo {
+	unionRegistry.RLock()
+	defer unionRegistry.RUnlock()
+	return unionRegistry.byIface[typ]
+}
+
+// packUnion writes the value of a union case as a map with the discriminator key first.
+func packUnion(e *Encoder, u *unionInfo, tag string, v reflect.Value) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return PackNil(e)
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("msgp: union case %q of type %v is not a struct", tag, v.Type())
+	}
+	return packStructAsMap(e, v, cachedStructInfo(v.Type(), e.tagName, e.naming), u.key, tag)
+}
+
+// unpackUnion reads a map into the interface value pointed by 'ptr' as the struct type
+// selected by the discriminator key. The map is buffered to find the key, and then unpacked.
+func unpackUnion(d *Decoder, u *unionInfo, ptr interface{}) error {
+	wantType := reflect.TypeOf(ptr).Elem()
+
+	head, err := d.peekByte()
+	if err != nil {
+		return err
+	}
+	if head == 0xc0 { // nil value unpacked.
+		d.r++
+		reflect.ValueOf(ptr).Elem().Set(reflect.Zero(wantType))
+		return nil
+	}
+
+	offset := d.offset()
+	if head&0xf0 != 0x80 && head != 0xde && head != 0xdf {
+		return typeError(head, offset, wantType, fmt.Sprintf("not a map with the key %q", u.key))
+	}
+	raw, err := appendRawValue(d, nil)
+	if err != nil {
+		return err
+	}
+
+	typ, err := u.findType(d.subDecoder(raw, offset), wantType)
+	if err != nil {
+		return err
+	}
+
+	val := reflect.New(typ)
+	sub := d.subDecoder(raw, offset)
+	sub.unionKey = u.key
+	if err = Unpack(sub, val.Interface()); err != nil {
+		return err
+	}
+	reflect.ValueOf(ptr).Elem().Set(val.Elem())
+	return nil
+}
+
+// findType reads the map to find the discriminator key and returns the type selected by the value.
+func (u *unionInfo) findType(d *Decoder, wantType reflect.Type) (reflect.Type, error) {
+	head, err := d.peekByte()
+	if err != nil {
+		return nil, err
+	}
+	offset := d.offset()
+
+	size, err := d.ReadMapHeader()
+	if err != nil {
+		return nil, err
+	}
+	for inx := 0; inx < size; inx++ {
+		key, err := d.ReadString()
+		if _, ok := err.(*UnpackTypeError); ok { // not a str
+			err = d.Skip()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key != u.key {
+			if err = d.Skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		valueHead, err := d.peekByte()
+		if err != nil {
+			return nil, err
+		}
+		valueOffset := d.offset()
+		tag, err := d.ReadString()
+		if err != nil {
+			return nil, typeError(valueHead, valueOffset, wantType, fmt.Sprintf("value of the key %q is not a str", u.key))
+		}
+		typ, ok := u.byTag[tag]
+		if !ok {
+			return nil, typeError(valueHead, valueOffset, wantType, fmt.Sprintf("unknown value %q of the key %q", tag, u.key))
+		}
+		if !typ.Implements(wantType) {
+			return nil, typeError(valueHead, valueOffset, wantType, fmt.Sprintf("type %v of %q does not implement it", typ, tag))
+		}
+		return typ, nil
+	}
+	return nil, typeError(head, offset, wantType, fmt.Sprintf("the key %q is missing", u.key))
+}
+
+// subDecoder returns a Decoder with the options and the depth of d, which reads 'raw', a value
+// already read by d at 'offset'. The offsets of the errors are from the beginning of the input of d.
+func (d *Decoder) subDecoder(raw []byte, offset int64) *Decoder {
+	sub := *d
+	sub.rd = bytes.NewReader(nil)
+	sub.buf, sub.r, sub.w = raw, 0, len(raw)
+	sub.read = offset + int64(len(raw))
+	sub.unionKey = ""
+	return &sub
+}
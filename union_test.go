@@ -0,0 +1,262 @@
+package msgp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type event interface {
+	eventName() string
+}
+
+type clickEvent struct {
+	X, Y int
+}
+
+func (clickEvent) eventName() string { return "click" }
+
+type keyEvent struct {
+	Type string `msgp:"type"`
+	Key  string `msgp:"key"`
+}
+
+func (*keyEvent) eventName() string { return "key" }
+
+type wheelEvent struct {
+	Type  string `msgp:"type,omitempty"`
+	Delta int    `msgp:"delta"`
+}
+
+func (wheelEvent) eventName() string { return "wheel" }
+
+type eventLog struct {
+	Events []event
+}
+
+func init() {
+	RegisterUnion((*event)(nil), "type", map[string]interface{}{
+		"click": clickEvent{},
+		"key":   &keyEvent{},
+		"wheel": wheelEvent{},
+	})
+}
+
+func ExampleRegisterUnion() {
+	// RegisterUnion((*event)(nil), "type", map[string]interface{}{
+	// 	"click": clickEvent{},
+	// 	"key":   &keyEvent{},
+	// })
+
+	// {"X": 1, "Y": 2, "type": "click"}: the discriminator is the last key.
+	data := []byte{0x83, 0xa1, 'X', 0x01, 0xa1, 'Y', 0x02, 0xa4, 't', 'y', 'p', 'e', 0xa5, 'c', 'l', 'i', 'c', 'k'}
+
+	var ev event
+	err := Unpack(bytes.NewReader(data), &ev)
+	fmt.Printf("%T %+v %v\n", ev, ev, err)
+
+	// Output:
+	// msgp.clickEvent {X:1 Y:2} <nil>
+}
+
+func TestUnionRoundTrip(t *testing.T) {
+	in := eventLog{Events: []event{
+		clickEvent{X: 3, Y: 4},
+		&keyEvent{Type: "key", Key: "a"},
+		nil,
+	}}
+
+	var buf bytes.Buffer
+	if err := Pack(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+
+	// the discriminator is packed first, and not twice for keyEvent which has the field.
+	var raw struct{ Events []map[string]interface{} }
+	if err := Unpack(bytes.NewReader(buf.Bytes()), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw.Events[0]) != 3 || raw.Events[0]["type"] != "click" || len(raw.Events[1]) != 2 {
+		t.Errorf("packed %v", raw.Events)
+	}
+
+	var out eventLog
+	if err := Unpack(&buf, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got %#v, want %#v", out, in)
+	}
+}
+
+func TestUnionDiscriminatorField(t *testing.T) {
+	// the key is written with the value of the case, whatever the field with the name holds.
+	tests := []struct {
+		in, want event
+	}{
+		{&keyEvent{Key: "a"}, &keyEvent{Type: "key", Key: "a"}},
+		{&keyEvent{Type: "other", Key: "a"}, &keyEvent{Type: "key", Key: "a"}},
+		{wheelEvent{Delta: 3}, wheelEvent{Type: "wheel", Delta: 3}},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := Pack(&buf, &test.in); err != nil {
+			t.Fatal(err)
+		}
+
+		var raw map[string]interface{}
+		if err := Unpack(bytes.NewReader(buf.Bytes()), &raw); err != nil {
+			t.Fatal(err)
+		}
+		if len(raw) != 2 || raw["type"] != test.want.eventName() {
+			t.Errorf("%#v: packed %v", test.in, raw)
+		}
+
+		var out event
+		if err := Unpack(&buf, &out); err != nil {
+			t.Fatalf("%#v: %v", test.in, err)
+		}
+		if !reflect.DeepEqual(out, test.want) {
+			t.Errorf("got %#v, want %#v", out, test.want)
+		}
+	}
+}
+
+type otherEvent struct{}
+
+func (otherEvent) eventName() string { return "other" }
+
+func TestUnionPointerCase(t *testing.T) {
+	in := eventLog{Events: []event{&clickEvent{X: 1, Y: 2}}}
+
+	var buf bytes.Buffer
+	if err := Pack(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	var out eventLog
+	if err := Unpack(&buf, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := []event{clickEvent{X: 1, Y: 2}}; !reflect.DeepEqual(out.Events, want) {
+		t.Errorf("got %#v, want %#v", out.Events, want)
+	}
+
+	// a type which is not a case can't be packed.
+	in = eventLog{Events: []event{otherEvent{}}}
+	err := Pack(&bytes.Buffer{}, in)
+	if err == nil || !strings.Contains(err.Error(), "msgp.otherEvent is not a case of the union msgp.event") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestUnionDiscriminatorPosition(t *testing.T) {
+	for _, keys := range [][]string{{"type", "key", "x"}, {"key", "type", "x"}, {"x", "key", "type"}} {
+		b := AppendMapHeader(nil, len(keys))
+		for _, key := range keys {
+			b = AppendString(b, key)
+			switch key {
+			case "type":
+				b = AppendString(b, "key")
+			case "key":
+				b = AppendString(b, "enter")
+			default:
+				b = AppendArrayHeader(b, 2) // a value to be skipped
+				b = AppendMapHeader(AppendInt(b, 1), 1)
+				b = AppendString(AppendInt(b, 1), "type")
+			}
+		}
+
+		dec := NewDecoder(bytes.NewReader(append(b, 0x07)))
+		var ev event
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatalf("%v: %v", keys, err)
+		}
+		if k, ok := ev.(*keyEvent); !ok || k.Key != "enter" || k.Type != "key" {
+			t.Errorf("%v: got %#v", keys, ev)
+		}
+
+		// the decoder is left just after the map.
+		var next int
+		if err := dec.Decode(&next); err != nil || next != 7 {
+			t.Errorf("%v: next value %d, err %v", keys, next, err)
+		}
+	}
+}
+
+func TestUnionStrictMode(t *testing.T) {
+	b := AppendMapHeader(nil, 2)
+	b = AppendString(AppendString(b, "type"), "click")
+	b = AppendInt(AppendString(b, "X"), 1)
+
+	dec := NewDecoder(bytes.NewReader(b))
+	dec.UseStrictMode(true)
+	var ev event
+	if err := dec.Decode(&ev); err != nil || ev != (clickEvent{X: 1}) {
+		t.Errorf("got %v, err %v", ev, err)
+	}
+
+	// the key is unknown to the nested structs.
+	type wrapper struct{ C clickEvent }
+	b = AppendMapHeader(nil, 1)
+	b = AppendMapHeader(AppendString(b, "C"), 1)
+	b = AppendString(AppendString(b, "type"), "click")
+	dec = NewDecoder(bytes.NewReader(b))
+	dec.UseStrictMode(true)
+	var w wrapper
+	var ue *UnknownFieldsError
+	if err := dec.Decode(&w); !errors.As(err, &ue) {
+		t.Errorf("got error %v", err)
+	}
+
+	// an empty key is unknown outside a union.
+	b = AppendMapHeader(nil, 1)
+	b = AppendInt(AppendString(b, ""), 1)
+	dec = NewDecoder(bytes.NewReader(b))
+	dec.UseStrictMode(true)
+	var c clickEvent
+	if err := dec.Decode(&c); !errors.As(err, &ue) || len(ue.Keys) != 1 || ue.Keys[0] != "" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestUnionErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		offset int64
+		reason string
+	}{
+		{"not a map", AppendArrayHeader(nil, 0), 9, `not a map with the key "type"`},
+		{"missing", AppendInt(AppendString(AppendMapHeader(nil, 1), "X"), 1), 9, `the key "type" is missing`},
+		{"not a str", AppendInt(AppendString(AppendMapHeader(nil, 1), "type"), 1), 15, `value of the key "type" is not a str`},
+		{"unknown", AppendString(AppendString(AppendMapHeader(nil, 1), "type"), "scroll"), 15, `unknown value "scroll" of the key "type"`},
+	}
+
+	for _, test := range tests {
+		data := append(AppendArrayHeader(AppendString(AppendMapHeader(nil, 1), "Events"), 1), test.data...)
+		var log eventLog
+		err := Unpack(bytes.NewReader(data), &log)
+
+		var te *UnpackTypeError
+		if !errors.As(err, &te) || te.Field != "eventLog.Events[0]" || te.Reason != test.reason || te.Offset != test.offset {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+	}
+
+	// an error in the selected struct has the path and the offset in the input.
+	data := append(AppendString(AppendMapHeader(nil, 1), "Events"), 0x91)
+	data = AppendMapHeader(data, 2)
+	data = AppendString(AppendString(data, "type"), "click")
+	data = AppendString(AppendString(data, "X"), "one")
+
+	var log eventLog
+	err := Unpack(bytes.NewReader(data), &log)
+	var te *UnpackTypeError
+	if !errors.As(err, &te) || te.Field != "eventLog.Events[0].X" || te.Offset != 23 {
+		t.Errorf("got error %v", err)
+	}
+}